@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/fiffeek/hyprwhenthen/internal/config"
+	"github.com/fiffeek/hyprwhenthen/internal/utils"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	convertTo string
+	configCmd = &cobra.Command{
+		Use:   "config",
+		Short: "Work with configuration files",
+	}
+	convertCmd = &cobra.Command{
+		Use:   "convert <input> [output]",
+		Short: "Convert a configuration file between TOML, YAML and JSON",
+		Long: "Convert a configuration file between TOML, YAML and JSON. The formats are detected from the file " +
+			"extensions, the output is printed when no output file is given. Includes are not followed and " +
+			"comments are not preserved.",
+		Args:          cobra.RangeArgs(1, 2),
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		RunE:          convert,
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(convertCmd)
+	convertCmd.Flags().StringVar(&convertTo, "to", "", "Output format (toml, yaml or json), defaults to the format of the output file")
+}
+
+func convert(cmd *cobra.Command, args []string) error {
+	input := args[0]
+	output := ""
+	if len(args) == 2 {
+		output = args[1]
+	}
+
+	to := ""
+	switch {
+	case convertTo != "":
+		var err error
+		if to, err = config.ParseFormat(convertTo); err != nil {
+			return err
+		}
+	case output != "":
+		to = config.DetectFormat(output)
+	default:
+		return errors.New("--to is required when printing the converted config")
+	}
+
+	// nolint:gosec
+	contents, err := os.ReadFile(input)
+	if err != nil {
+		return fmt.Errorf("cant read config file %s: %w", input, err)
+	}
+	from := config.DetectFormat(input)
+	converted, err := config.Convert(contents, from, to)
+	if err != nil {
+		return fmt.Errorf("cant convert %s: %w", input, err)
+	}
+
+	if output == "" {
+		_, err := os.Stdout.Write(converted)
+		return err
+	}
+	if err := utils.WriteAtomic(output, converted); err != nil {
+		return fmt.Errorf("cant write %s: %w", output, err)
+	}
+	logrus.WithFields(logrus.Fields{"from": from, "to": to, "output": output}).Info("Config converted")
+	return nil
+}
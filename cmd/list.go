@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/fiffeek/hyprwhenthen/internal/config"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	listTag string
+	listCmd = &cobra.Command{
+		Use:           "list",
+		Short:         "List configured handlers",
+		Long:          "List the handlers from the configuration file grouped by the event type they react to.",
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		RunE:          list,
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(listCmd)
+	listCmd.Flags().StringVar(&listTag, "tag", "", "Only list handlers with the tag")
+}
+
+func list(cmd *cobra.Command, args []string) error {
+	cfg, err := config.NewConfig(configPath)
+	if err != nil {
+		return fmt.Errorf("configuration is invalid: %w", err)
+	}
+	raw := cfg.Get()
+
+	eventTypes := slices.Clone(raw.EventKeys)
+	slices.Sort(eventTypes)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, eventType := range eventTypes {
+		handlers := slices.DeleteFunc(slices.Clone(raw.OnEvents[eventType]), func(handler *config.Event) bool {
+			return listTag != "" && !handler.HasTag(listTag)
+		})
+		if len(handlers) == 0 {
+			continue
+		}
+		_, _ = fmt.Fprintf(w, "%s\n", eventType)
+		for _, handler := range handlers {
+			description := ""
+			if handler.Description != nil {
+				description = *handler.Description
+			}
+			_, _ = fmt.Fprintf(w, "  %s\n",
+				tableRow(handler.Label(), handler.When, strings.Join(handler.Tags, ","), description))
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("cant write handlers: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/fiffeek/hyprwhenthen/internal/config"
+	"github.com/fiffeek/hyprwhenthen/internal/profile"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	profileCmd = &cobra.Command{
+		Use:   "profile",
+		Short: "Show and switch profiles",
+		Long: "Show and switch the active profiles. The state is persisted and the running service " +
+			"is notified, so the change applies immediately and survives restarts.",
+	}
+	profileListCmd = &cobra.Command{
+		Use:           "list",
+		Short:         "List profiles and whether they are active",
+		Args:          cobra.NoArgs,
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		RunE:          profileList,
+	}
+	profileEnableCmd  = switchProfileCmd("enable", "Activate a profile, deactivating the others of its group", (*profile.State).Enable)
+	profileDisableCmd = switchProfileCmd("disable", "Deactivate a profile", (*profile.State).Disable)
+	profileToggleCmd  = switchProfileCmd("toggle", "Activate a profile if it is inactive, deactivate it otherwise", (*profile.State).Toggle)
+)
+
+func init() {
+	rootCmd.AddCommand(profileCmd)
+	profileCmd.AddCommand(profileListCmd, profileEnableCmd, profileDisableCmd, profileToggleCmd)
+}
+
+func switchProfileCmd(use, short string, change func(*profile.State, *config.RawConfig, string) error) *cobra.Command {
+	return &cobra.Command{
+		Use:           use + " <profile>",
+		Short:         short,
+		Args:          cobra.ExactArgs(1),
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.NewConfig(configPath)
+			if err != nil {
+				return fmt.Errorf("configuration is invalid: %w", err)
+			}
+			state, err := profile.ReadState(cfg.Get())
+			if err != nil {
+				return err
+			}
+			if err := change(state, cfg.Get(), args[0]); err != nil {
+				return err
+			}
+			if err := profile.WriteState(cfg.Get(), state); err != nil {
+				return fmt.Errorf("cant save profiles: %w", err)
+			}
+			fields := logrus.Fields{"profile": args[0], "active": strings.Join(state.Active, ",")}
+			if err := profile.Notify(cfg.Get()); err != nil {
+				if !errors.Is(err, profile.ErrNotRunning) {
+					return err
+				}
+				logrus.WithFields(fields).Info("Profiles saved, the service is not running")
+				return nil
+			}
+			logrus.WithFields(fields).Info("Profiles switched")
+			return nil
+		},
+	}
+}
+
+func profileList(cmd *cobra.Command, args []string) error {
+	cfg, err := config.NewConfig(configPath)
+	if err != nil {
+		return fmt.Errorf("configuration is invalid: %w", err)
+	}
+	raw := cfg.Get()
+	state, err := profile.ReadState(raw)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, name := range raw.ProfileNames() {
+		p := raw.Profiles[name]
+		marker := " "
+		if state.IsActive(name) {
+			marker = "*"
+		}
+		group, description := "", ""
+		if p.Group != nil {
+			group = *p.Group
+		}
+		if p.Description != nil {
+			description = *p.Description
+		}
+		_, _ = fmt.Fprintf(w, "%s\n", tableRow(marker+" "+name, group, description))
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("cant write profiles: %w", err)
+	}
+	return nil
+}
@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/fiffeek/hyprwhenthen/internal/signal"
+	"github.com/fiffeek/hyprwhenthen/internal/workerpool"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -30,7 +31,7 @@ var (
 		Short:            "Event-driven automation for Hyprland",
 		Long:             "HyprWhenThen is an automation tool that listens to Hyprland events and executes actions based on configured rules.",
 		Version:          fmt.Sprintf("%s (commit %s, built %s)", Version, Commit, BuildDate),
-		PersistentPreRun: setupLogger,
+		PersistentPreRun: preRun,
 	}
 )
 
@@ -51,6 +52,15 @@ func Execute() {
 	logrus.Debug("Exiting...")
 }
 
+// preRun configures logging and picks up the config of the running service
+// when invoked from a handler, e.g. `hyprwhenthen profile enable docked`.
+func preRun(cmd *cobra.Command, args []string) {
+	setupLogger(cmd, args)
+	if path := os.Getenv(workerpool.ConfigEnvVar); path != "" && !cmd.Flags().Changed("config") {
+		configPath = path
+	}
+}
+
 func setupLogger(cmd *cobra.Command, args []string) {
 	if debug {
 		logrus.SetLevel(logrus.DebugLevel)
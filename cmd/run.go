@@ -13,6 +13,7 @@ import (
 var (
 	workers   int
 	queueSize int
+	dryRun    bool
 	runCmd    = &cobra.Command{
 		Use:           "run",
 		Short:         "Start the HyprWhenThen service",
@@ -29,13 +30,19 @@ func init() {
 		&workers,
 		"workers",
 		2,
-		"Number of background workers",
+		"Number of background workers, overrides general.workers",
 	)
 	runCmd.Flags().IntVar(
 		&queueSize,
 		"queue",
 		10,
-		"Events are queued for each worker, this defines the queue size; the dispatcher will wait for a free slot when the worker is running behind",
+		"Events are queued for each worker, this defines the queue size; the dispatcher will wait for a free slot when the worker is running behind; overrides general.queue_size",
+	)
+	runCmd.Flags().BoolVar(
+		&dryRun,
+		"dry-run",
+		false,
+		"Log the jobs (command, env and timing) instead of executing them; overrides general.dry_run until it is changed in the config",
 	)
 }
 
@@ -45,7 +52,20 @@ func run(cmd *cobra.Command, args []string) error {
 	ctx, cancel := context.WithCancelCause(context.Background())
 	defer cancel(context.Canceled)
 
-	app, err := app.NewApplication(ctx, cancel, configPath, workers, queueSize)
+	// The flags only take precedence over the config when they are set explicitly.
+	var workersOverride, queueSizeOverride *int
+	var dryRunOverride *bool
+	if cmd.Flags().Changed("workers") {
+		workersOverride = &workers
+	}
+	if cmd.Flags().Changed("queue") {
+		queueSizeOverride = &queueSize
+	}
+	if cmd.Flags().Changed("dry-run") {
+		dryRunOverride = &dryRun
+	}
+
+	app, err := app.NewApplication(ctx, cancel, configPath, workersOverride, queueSizeOverride, dryRunOverride)
 	if err != nil {
 		return fmt.Errorf("failed on app creation: %w", err)
 	}
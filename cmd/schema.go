@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/fiffeek/hyprwhenthen/internal/config"
+
+	"github.com/spf13/cobra"
+)
+
+var schemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Print the JSON schema of the configuration file",
+	Long: "Print the JSON schema of the configuration file, editors use it for completion and validation of " +
+		"TOML, YAML and JSON configs.",
+	Args:          cobra.NoArgs,
+	SilenceErrors: true,
+	SilenceUsage:  true,
+	RunE:          schema,
+}
+
+func init() {
+	rootCmd.AddCommand(schemaCmd)
+}
+
+func schema(cmd *cobra.Command, args []string) error {
+	contents, err := config.MarshalSchema()
+	if err != nil {
+		return fmt.Errorf("cant generate the schema: %w", err)
+	}
+	_, err = os.Stdout.Write(contents)
+	return err
+}
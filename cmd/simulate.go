@@ -0,0 +1,169 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/fiffeek/hyprwhenthen/internal/config"
+	"github.com/fiffeek/hyprwhenthen/internal/eventprocessor"
+	"github.com/fiffeek/hyprwhenthen/internal/hypr"
+	"github.com/fiffeek/hyprwhenthen/internal/profile"
+	"github.com/fiffeek/hyprwhenthen/internal/sessionenv"
+	"github.com/fiffeek/hyprwhenthen/internal/workerpool"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	simulateFile    string
+	simulateWorkers int
+	simulateFullEnv bool
+	simulateCmd     = &cobra.Command{
+		Use:   "simulate [event...]",
+		Short: "Show what the service would do with events",
+		Long: "Match Hyprland events against the configuration and print the handlers that would run, " +
+			"with the captures, the environment, the routing key and the worker of each job. " +
+			"Events are given as IPC lines, e.g. 'windowtitlev2>>558f74f82570,Mozilla Firefox'. " +
+			"Nothing is executed.",
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		RunE:          simulate,
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(simulateCmd)
+	simulateCmd.Flags().StringVar(&simulateFile, "file", "",
+		"Read the events from a file, one per line, '-' reads them from stdin")
+	simulateCmd.Flags().IntVar(&simulateWorkers, "workers", 2,
+		"Number of background workers the jobs are spread over, overrides general.workers")
+	simulateCmd.Flags().BoolVar(&simulateFullEnv, "full-env", false,
+		"Also print the variables that jobs inherit from the session environment")
+}
+
+func simulate(cmd *cobra.Command, args []string) error {
+	lines := slices.Clone(args)
+	if simulateFile != "" {
+		fromFile, err := readEventLines(simulateFile)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, fromFile...)
+	}
+	if len(lines) == 0 {
+		return errors.New("no events given, pass them as arguments or with --file")
+	}
+
+	cfg, err := config.NewConfig(configPath)
+	if err != nil {
+		return fmt.Errorf("configuration is invalid: %w", err)
+	}
+	raw := cfg.Get()
+	state, err := profile.ReadState(raw)
+	if err != nil {
+		return err
+	}
+	workers := *raw.General.Workers
+	if cmd.Flags().Changed("workers") {
+		workers = simulateWorkers
+	}
+	if workers <= 0 {
+		return errors.New("workers must be positive")
+	}
+	session := sessionenv.NewService(cfg).Environ()
+
+	w := bufio.NewWriter(os.Stdout)
+	for i, line := range lines {
+		if i > 0 {
+			_, _ = fmt.Fprintln(w)
+		}
+		if err := simulateEvent(w, raw, state, line, workers, session); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("cant write simulation: %w", err)
+	}
+	return nil
+}
+
+func simulateEvent(w io.Writer, cfg *config.RawConfig, state *profile.State, line string, workers int, session []string) error {
+	if !strings.Contains(line, ">>") {
+		return fmt.Errorf("event %q is invalid, expected TYPE>>DATA", line)
+	}
+	_, _ = fmt.Fprintln(w, line)
+	found, event := hypr.RegisteredEvent(cfg, line)
+	if !found {
+		_, _ = fmt.Fprintln(w, "  no handlers react to the event type")
+		return nil
+	}
+	outcomes, err := eventprocessor.Match(cfg, state.HandlerActive, session, nil, event)
+	if err != nil {
+		return err
+	}
+
+	for _, outcome := range outcomes {
+		handler := outcome.Handler
+		location := config.Diagnostic{File: handler.Source, Line: handler.Line, Column: handler.Column}.Location()
+		job := outcome.Job
+		if job == nil {
+			_, _ = fmt.Fprintf(w, "  %s (%s): skipped, %s\n", handler.Label(), location, outcome.Reason)
+			continue
+		}
+		worker, err := workerpool.WorkerIndex(job.RoutingKey, workers)
+		if err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintf(w, "  %s (%s): runs\n", handler.Label(), location)
+		_, _ = fmt.Fprintf(w, "    captures:    %q\n", outcome.Captures)
+		_, _ = fmt.Fprintf(w, "    exec:        %s\n", job.Exec)
+		routingKey := job.RoutingKey
+		if routingKey == job.ID.String() {
+			// Without a routing key every job gets its own, so the worker is random.
+			routingKey += " (job id)"
+		}
+		_, _ = fmt.Fprintf(w, "    routing key: %s\n", routingKey)
+		_, _ = fmt.Fprintf(w, "    worker:      %d\n", worker)
+		_, _ = fmt.Fprintln(w, "    env:")
+		var base []string
+		if simulateFullEnv {
+			base = session
+		}
+		env := job.Environ(base)
+		slices.Sort(env)
+		for _, entry := range env {
+			_, _ = fmt.Fprintf(w, "      %s\n", entry)
+		}
+	}
+	return nil
+}
+
+// readEventLines reads the events from the file, skipping empty lines and
+// comments.
+func readEventLines(path string) ([]string, error) {
+	var contents []byte
+	var err error
+	if path == "-" {
+		contents, err = io.ReadAll(os.Stdin)
+	} else {
+		// nolint:gosec
+		contents, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cant read events: %w", err)
+	}
+	var lines []string
+	for line := range strings.Lines(string(contents)) {
+		line = strings.TrimRight(line, "\r\n")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
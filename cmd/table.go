@@ -0,0 +1,12 @@
+package cmd
+
+import "strings"
+
+// tableRow joins the cells for a tabwriter, empty trailing cells are dropped
+// as they would be padded with spaces.
+func tableRow(cells ...string) string {
+	for len(cells) > 1 && cells[len(cells)-1] == "" {
+		cells = cells[:len(cells)-1]
+	}
+	return strings.Join(cells, "\t")
+}
@@ -1,28 +1,126 @@
 package cmd
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
 	"github.com/fiffeek/hyprwhenthen/internal/config"
 
+	"github.com/BurntSushi/toml"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
-var validateCmd = &cobra.Command{
-	Use:   "validate",
-	Short: "Validate configuration file",
-	Long:  "Validate the syntax and structure of the HyprWhenThen configuration file.",
-	Run:   validate,
+const (
+	validateFormatText = "text"
+	validateFormatJSON = "json"
+)
+
+var (
+	validatePrint  bool
+	validateStrict bool
+	validateFormat string
+	validateCmd    = &cobra.Command{
+		Use:   "validate",
+		Short: "Validate configuration file",
+		Long: "Validate the syntax and structure of the HyprWhenThen configuration file. All the errors are " +
+			"reported at once, along with warnings about likely mistakes that don't make the config invalid.",
+		Run: validate,
+	}
+)
+
+// validateReport is the output of `validate --format json`.
+type validateReport struct {
+	Valid       bool                `json:"valid"`
+	Format      string              `json:"format,omitempty"`
+	Diagnostics []config.Diagnostic `json:"diagnostics"`
 }
 
 func init() {
 	rootCmd.AddCommand(validateCmd)
+	validateCmd.Flags().BoolVar(&validatePrint, "print", false,
+		"Print the handlers with templates, variables and defaults applied")
+	validateCmd.Flags().BoolVar(&validateStrict, "strict", false,
+		"Check the shell commands: syntax, programs on PATH and the use of capture groups")
+	validateCmd.Flags().StringVar(&validateFormat, "format", validateFormatText,
+		"Output format of the errors and warnings (text or json)")
 }
 
 func validate(cmd *cobra.Command, args []string) {
 	logrus.WithField("version", Version).Debug("Validating configuration")
-	_, err := config.NewConfig(configPath)
+	if validateFormat != validateFormatText && validateFormat != validateFormatJSON {
+		logrus.WithError(fmt.Errorf("format has to be one of %q or %q", validateFormatText, validateFormatJSON)).
+			Fatal("Invalid flags")
+	}
+	if validatePrint && validateFormat == validateFormatJSON {
+		logrus.WithError(errors.New("--print can't be used with --format json")).Fatal("Invalid flags")
+	}
+
+	report := validateReport{Valid: true}
+	cfg, err := config.NewConfig(configPath)
 	if err != nil {
-		logrus.WithError(err).Fatal("Configuration is invalid")
+		report.Valid = false
+		report.Diagnostics = config.Diagnostics(err)
+	} else {
+		report.Format = cfg.Get().Format
+		report.Diagnostics = cfg.Get().Lint()
+	}
+	if err == nil && validateStrict {
+		strict := cfg.Get().Strict(cmd.Context())
+		report.Valid = !config.HasErrors(strict)
+		report.Diagnostics = append(report.Diagnostics, strict...)
+	}
+
+	if validateFormat == validateFormatJSON {
+		printReport(report)
+		return
+	}
+
+	errorCount := 0
+	for _, diagnostic := range report.Diagnostics {
+		entry := logrus.NewEntry(logrus.StandardLogger())
+		if location := diagnostic.Location(); location != "" {
+			entry = entry.WithField("location", location)
+		}
+		if diagnostic.Severity == config.SeverityError {
+			errorCount++
+			entry.Error(diagnostic.Message)
+			continue
+		}
+		entry.Warn(diagnostic.Message)
+	}
+	if !report.Valid {
+		logrus.WithFields(logrus.Fields{
+			"errors":   errorCount,
+			"warnings": len(report.Diagnostics) - errorCount,
+		}).Fatal("Configuration is invalid")
+	}
+	if validatePrint {
+		expanded := struct {
+			Handlers []*config.Event `toml:"handler"`
+		}{Handlers: cfg.Get().Events}
+		encoder := toml.NewEncoder(os.Stdout)
+		encoder.Indent = ""
+		if err := encoder.Encode(expanded); err != nil {
+			logrus.WithError(err).Fatal("Cant print the configuration")
+		}
+	}
+	logrus.WithField("format", report.Format).Info("Configuration is valid")
+}
+
+func printReport(report validateReport) {
+	if report.Diagnostics == nil {
+		report.Diagnostics = []config.Diagnostic{}
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(report); err != nil {
+		logrus.WithError(err).Fatal("Cant print the report")
+	}
+	if !report.Valid {
+		os.Exit(1)
 	}
-	logrus.Info("Configuration is valid")
 }
@@ -11,6 +11,8 @@ import (
 	"github.com/fiffeek/hyprwhenthen/internal/eventprocessor"
 	"github.com/fiffeek/hyprwhenthen/internal/filewatcher"
 	"github.com/fiffeek/hyprwhenthen/internal/hypr"
+	"github.com/fiffeek/hyprwhenthen/internal/profile"
+	"github.com/fiffeek/hyprwhenthen/internal/sessionenv"
 	"github.com/fiffeek/hyprwhenthen/internal/signal"
 	"github.com/fiffeek/hyprwhenthen/internal/workerpool"
 
@@ -22,13 +24,14 @@ type Application struct {
 	cfg            *config.Config
 	hypr           *hypr.Service
 	pool           *workerpool.Service
+	profiles       *profile.Service
 	eventProcessor *eventprocessor.Service
 	startOnce      sync.Once
 	signalHandler  *signal.Handler
 	watcher        *filewatcher.Service
 }
 
-func NewApplication(ctx context.Context, cancelCause context.CancelCauseFunc, configPath string, workersNum, queueSize int) (*Application, error) {
+func NewApplication(ctx context.Context, cancelCause context.CancelCauseFunc, configPath string, workers, queueSize *int, dryRun *bool) (*Application, error) {
 	cfg, err := config.NewConfig(configPath)
 	if err != nil {
 		return nil, fmt.Errorf("cant load config: %w", err)
@@ -36,17 +39,27 @@ func NewApplication(ctx context.Context, cancelCause context.CancelCauseFunc, co
 
 	watcher := filewatcher.NewService(cfg, cfg)
 
-	hypr, err := hypr.NewService(ctx, cfg)
+	sessionEnv := sessionenv.NewService(cfg)
+	cfg.AddReloadListener(sessionEnv)
+
+	hypr, err := hypr.NewService(ctx, cfg, sessionEnv)
 	if err != nil {
 		return nil, fmt.Errorf("cant init hypr: %w", err)
 	}
 
-	pool, err := workerpool.NewService(workersNum, queueSize, cfg)
+	pool, err := workerpool.NewService(cfg, sessionEnv, workers, queueSize, dryRun)
 	if err != nil {
 		return nil, fmt.Errorf("cant init pool: %w", err)
 	}
+	cfg.AddReloadListener(pool)
+
+	profiles, err := profile.NewService(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("cant init profiles: %w", err)
+	}
+	cfg.AddReloadListener(profiles)
 
-	processor, err := eventprocessor.NewService(hypr, pool, cfg)
+	processor, err := eventprocessor.NewService(hypr, pool, profiles, cfg)
 	if err != nil {
 		return nil, fmt.Errorf("cant init event processor: %w", err)
 	}
@@ -57,6 +70,7 @@ func NewApplication(ctx context.Context, cancelCause context.CancelCauseFunc, co
 		cfg:            cfg,
 		hypr:           hypr,
 		pool:           pool,
+		profiles:       profiles,
 		eventProcessor: processor,
 		signalHandler:  handler,
 		watcher:        watcher,
@@ -82,6 +96,7 @@ func (a *Application) run(ctx context.Context) error {
 		{Fun: a.pool.Run, Name: "bg worker pool"},
 		{Fun: a.eventProcessor.Run, Name: "event processor"},
 		{Fun: a.signalHandler.Run, Name: "signal handler"},
+		{Fun: a.profiles.Run, Name: "profiles"},
 		{Fun: a.watcher.Run, Name: "watch config changes"},
 	}
 	for _, bg := range backgroundGoroutines {
@@ -0,0 +1,73 @@
+// Package condition provides the `if` expressions of handlers, evaluated with
+// expr (https://expr-lang.org) after the regex matches.
+package condition
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+// Env is the data that an expression can access, the expr tags are the
+// names visible to the expression.
+type Env struct {
+	Event    Event             `expr:"event"`
+	Captures []string          `expr:"captures"`
+	Env      map[string]string `expr:"env"`
+	// State holds the values that scripts keep in their state store.
+	State map[string]any `expr:"state"`
+	Now   time.Time      `expr:"now"`
+}
+
+type Event struct {
+	Type    string `expr:"type"`
+	Context string `expr:"context"`
+	// Fields is the context split on commas, the way Hyprland separates event arguments.
+	Fields []string  `expr:"fields"`
+	Time   time.Time `expr:"time"`
+}
+
+// NewEvent creates the event part of the environment.
+func NewEvent(eventType, context string, eventTime time.Time) Event {
+	return Event{
+		Type:    eventType,
+		Context: context,
+		Fields:  strings.Split(context, ","),
+		Time:    eventTime,
+	}
+}
+
+// Condition is a compiled expression.
+type Condition struct {
+	source  string
+	program *vm.Program
+}
+
+// Compile type-checks the expression against Env, it has to evaluate to a boolean.
+func Compile(source string) (*Condition, error) {
+	program, err := expr.Compile(source, expr.Env(Env{}), expr.AsBool())
+	if err != nil {
+		return nil, fmt.Errorf("cant compile expression: %w", err)
+	}
+	return &Condition{source: source, program: program}, nil
+}
+
+func (c *Condition) String() string {
+	return c.source
+}
+
+// Eval runs the expression, runtime errors (e.g. an out of range capture) are returned.
+func (c *Condition) Eval(env *Env) (bool, error) {
+	out, err := expr.Run(c.program, *env)
+	if err != nil {
+		return false, fmt.Errorf("cant evaluate expression %q: %w", c.source, err)
+	}
+	result, ok := out.(bool)
+	if !ok {
+		return false, fmt.Errorf("expression %q did not return a boolean", c.source)
+	}
+	return result, nil
+}
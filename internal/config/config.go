@@ -6,20 +6,33 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"text/template"
 	"time"
 
-	"github.com/BurntSushi/toml"
+	"github.com/fiffeek/hyprwhenthen/internal/condition"
+	"github.com/fiffeek/hyprwhenthen/internal/script"
+	"github.com/fiffeek/hyprwhenthen/internal/tmpl"
 	"github.com/fiffeek/hyprwhenthen/internal/utils"
 	"github.com/sirupsen/logrus"
 )
 
 type Config struct {
-	cfg  *RawConfig
-	path string
-	mu   sync.RWMutex
+	cfg       *RawConfig
+	path      string
+	mu        sync.RWMutex
+	listeners []ReloadListener
+}
+
+// ReloadListener is notified after the config has been successfully reloaded.
+type ReloadListener interface {
+	OnConfigReload(context.Context) error
 }
 
 func NewConfig(path string) (*Config, error) {
@@ -41,8 +54,42 @@ func (c *Config) Get() *RawConfig {
 	return c.cfg
 }
 
-func (c *Config) OnEvent(context.Context) error {
-	return c.Reload()
+func (c *Config) AddReloadListener(listener ReloadListener) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.listeners = append(c.listeners, listener)
+}
+
+// OnEvent reloads the config, an invalid config is reported but does not stop
+// the service: the last valid config stays in use. The listeners are only
+// notified when the reload changed something.
+func (c *Config) OnEvent(ctx context.Context) error {
+	previous := c.Get()
+	if err := c.Reload(); err != nil {
+		logrus.WithError(err).Error("Config reload failed, keeping the previous configuration")
+		c.runReloadErrorHook(ctx, err)
+		return nil
+	}
+	current := c.Get()
+	diff := Compare(previous, current)
+	logrus.WithFields(logrus.Fields{
+		"path": c.path, "generation": current.Generation,
+		"added": strings.Join(diff.Added, ","), "removed": strings.Join(diff.Removed, ","),
+		"modified": strings.Join(diff.Modified, ","),
+	}).Info("Config reloaded")
+	if diff.Empty() {
+		logrus.Info("Config did not change, skipping reload listeners")
+		return nil
+	}
+	c.mu.RLock()
+	listeners := c.listeners
+	c.mu.RUnlock()
+	for _, listener := range listeners {
+		if err := listener.OnConfigReload(ctx); err != nil {
+			logrus.WithError(err).Warn("Config reload listener failed")
+		}
+	}
+	return nil
 }
 
 func (c *Config) Reload() error {
@@ -52,29 +99,188 @@ func (c *Config) Reload() error {
 	if err != nil {
 		return fmt.Errorf("cant reload config from %s: %w", c.path, err)
 	}
+	cfg.Generation = 1
+	if c.cfg != nil {
+		cfg.Generation = c.cfg.Generation + 1
+	}
 	c.cfg = cfg
 	return nil
 }
 
 type RawConfig struct {
 	Dir       string              `toml:"-"`
-	Events    []*Event            `toml:"handler"`
+	Include   []string            `toml:"include" doc:"Files or glob patterns, relative to the config directory, merged into this config"`
+	Vars      map[string]string   `toml:"vars" doc:"Variables referenced as ${var.<name>} in when, then and routing_key"`
+	Profiles  map[string]*Profile `toml:"profile" doc:"Profiles that handlers can belong to, switched at runtime"`
+	Templates []*Event            `toml:"template" doc:"Handler templates that handlers can extend"`
+	Events    []*Event            `toml:"handler" doc:"Event handlers"`
 	OnEvents  map[string][]*Event `toml:"-"`
 	EventKeys []string            `toml:"-"`
-	General   *GeneralSection     `toml:"general"`
+	General   *GeneralSection     `toml:"general" doc:"Service wide settings"`
+	// Files are the absolute paths of the loaded files, the main config first.
+	Files []string `toml:"-"`
+	// Format is the format of the main config file.
+	Format string `toml:"-"`
+	// Generation is incremented on every successful reload, starting at 1.
+	Generation      uint64 `toml:"-"`
+	includeDirs     []string
+	includePatterns []string
+	fingerprints    map[string]bool
+	// warnings are the unknown keys found while decoding the files.
+	warnings []Diagnostic
+	// general points at the [general] table that was loaded last.
+	general Diagnostic
+}
+
+// Profile is a named set of handlers that can be switched on and off at
+// runtime, activating a profile deactivates the others of its group.
+type Profile struct {
+	Description *string `toml:"description" doc:"Shown by hyprwhenthen profile list"`
+	Group       *string `toml:"group" doc:"Activating a profile deactivates the others of its group"`
+	Default     *bool   `toml:"default" doc:"Active until profiles are switched for the first time"`
 }
 
 type GeneralSection struct {
-	Timeout                *time.Duration `toml:"timeout"`
-	HotReloadDebounceTimer *time.Duration `toml:"hot_reload_debounce_timer"`
+	Timeout                *time.Duration `toml:"timeout" doc:"Timeout of the handler commands"`
+	HotReloadDebounceTimer *time.Duration `toml:"hot_reload_debounce_timer" doc:"Debounce time for config reloading, defaults to 1s"`
+	MaxOutputSize          *int           `toml:"max_output_size" doc:"Max bytes captured per output stream of a command, defaults to 64KiB"`
+	SessionEnv             *string        `toml:"session_env" doc:"Where the session environment comes from, defaults to daemon"`
+	SessionEnvFile         *string        `toml:"session_env_file" doc:"Env file for session_env = \"file\", relative to the config directory"`
+	OnReloadError          *string        `toml:"on_reload_error" doc:"Command to run when a reload fails, the error is in $HWT_RELOAD_ERROR"`
+	Workers                *int           `toml:"workers" doc:"Number of background workers, defaults to 2"`
+	QueueSize              *int           `toml:"queue_size" doc:"Jobs queued per worker before the dispatcher waits, defaults to 10"`
+	DryRun                 *bool          `toml:"dry_run" doc:"Log the jobs instead of running them, events are still matched and routed"`
+}
+
+const (
+	SessionEnvDaemon   = "daemon"
+	SessionEnvHyprland = "hyprland"
+	SessionEnvFile     = "file"
+)
+
+const (
+	StdinNone = "none"
+	StdinJSON = "json"
+)
+
+const defaultCheckTimeout = time.Second
+
+const (
+	defaultWorkers   = 2
+	defaultQueueSize = 10
+)
+
+// namePattern keeps handler names usable as file names and in logs.
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+const (
+	OnReloadRun    = "run"
+	OnReloadCancel = "cancel"
+)
+
+const (
+	OnFailureAbort    = "abort"
+	OnFailureContinue = "continue"
+)
+
+// Step is a single command of a multi-step handler.
+type Step struct {
+	Run       string         `toml:"run" doc:"Command to execute"`
+	Timeout   *time.Duration `toml:"timeout" doc:"Timeout of the step, defaults to the handler timeout"`
+	OnFailure *string        `toml:"on_failure" doc:"Whether to abort the handler or continue with the next step on failure, defaults to abort"`
+
+	// RunTemplate is compiled when Template is enabled on the handler.
+	RunTemplate *template.Template `toml:"-"`
+}
+
+func (s *Step) Validate() error {
+	if s.Run == "" {
+		return errors.New("'run' field is required")
+	}
+	if s.Timeout != nil && *s.Timeout <= 0 {
+		return errors.New("timeout must be positive")
+	}
+	if s.OnFailure == nil {
+		s.OnFailure = utils.JustPtr(OnFailureAbort)
+	}
+	if *s.OnFailure != OnFailureAbort && *s.OnFailure != OnFailureContinue {
+		return fmt.Errorf("on_failure has to be one of %q or %q", OnFailureAbort, OnFailureContinue)
+	}
+	return nil
+}
+
+const (
+	RetryOnTimeout    = "timeout"
+	RetryOnExitPrefix = "exit:"
+)
+
+// RetryCondition is a parsed retry_on entry, ExitCode is only set when
+// Timeout is false.
+type RetryCondition struct {
+	Timeout  bool
+	ExitCode int
 }
 
 type Event struct {
-	On         string         `toml:"on"`
-	When       string         `toml:"when"`
-	Then       string         `toml:"then"`
-	Timeout    *time.Duration `toml:"timeout"`
-	RoutingKey *string        `toml:"routing_key"`
+	Name          *string           `toml:"name" doc:"Unique name used in logs, outputs and $HWT_HANDLER"`
+	Description   *string           `toml:"description" doc:"Shown by hyprwhenthen list"`
+	Tags          []string          `toml:"tags" doc:"Used to filter hyprwhenthen list --tag"`
+	Extends       *string           `toml:"extends" doc:"Name of the template to take unset fields from"`
+	Profile       *string           `toml:"profile" doc:"Only react while the profile is active"`
+	On            string            `toml:"on" doc:"Hyprland event type, e.g. windowtitlev2"`
+	When          string            `toml:"when" doc:"Regex matched against the event data"`
+	If            *string           `toml:"if" doc:"Expression evaluated after the regex matches"`
+	Then          string            `toml:"then" doc:"Command to execute"`
+	Steps         []Step            `toml:"steps" doc:"Commands run one after another, alternative to then"`
+	Timeout       *time.Duration    `toml:"timeout" doc:"Overrides the general timeout"`
+	RoutingKey    *string           `toml:"routing_key" doc:"Jobs with the same routing key run serially, in order"`
+	Retries       *int              `toml:"retries" doc:"Retries of failed executions, defaults to 0"`
+	RetryBackoff  *time.Duration    `toml:"retry_backoff" doc:"Initial backoff, doubled on each retry, defaults to 100ms"`
+	RetryOn       []string          `toml:"retry_on" doc:"Failures to retry on (timeout or exit:<code>), defaults to any failure"`
+	Stdout        *string           `toml:"stdout" doc:"Where to route stdout: discard, log, log:<level>, file:<path> or rotate, defaults to log:debug"`
+	Stderr        *string           `toml:"stderr" doc:"Where to route stderr: discard, log, log:<level>, file:<path> or rotate, defaults to log:debug"`
+	MaxOutputSize *int              `toml:"max_output_size" doc:"Overrides the general max_output_size"`
+	Env           map[string]string `toml:"env" doc:"Extra environment variables"`
+	EnvFile       *string           `toml:"env_file" doc:"KEY=VALUE file, relative to the config directory"`
+	Workdir       *string           `toml:"workdir" doc:"Working directory, relative to the config directory, defaults to it"`
+	InheritEnv    *bool             `toml:"inherit_env" doc:"Start from the service environment, defaults to true"`
+	EnvAllowlist  []string          `toml:"env_allowlist" doc:"Inherited variables (glob patterns) when inherit_env = false"`
+	Stdin         *string           `toml:"stdin" doc:"Set to json to write the event to stdin, defaults to none"`
+	Template      *bool             `toml:"template" doc:"Render then and routing_key as Go templates, defaults to false"`
+	Coprocess     *string           `toml:"coprocess" doc:"Long-lived process fed with events over stdin, alternative to then"`
+	Script        *string           `toml:"script" doc:"Inline Starlark script, alternative to then"`
+	ScriptFile    *string           `toml:"script_file" doc:"Starlark script file, relative to the config directory, alternative to then"`
+	Check         *string           `toml:"check" doc:"Command that has to exit 0 for the handler to run"`
+	CheckTimeout  *time.Duration    `toml:"check_timeout" doc:"Timeout of the check, defaults to 1s"`
+	CheckCacheTTL *time.Duration    `toml:"check_cache_ttl" doc:"Cache check results per routing key, defaults to no caching"`
+	OnReload      *string           `toml:"on_reload" doc:"Whether queued jobs run or are dropped when a reload removes or changes the handler, defaults to run"`
+
+	// Fields below are derived during validation.
+	Index int `toml:"-"`
+	// Source, Line and Column point at the definition of the handler.
+	Source       string  `toml:"-"`
+	Line         int     `toml:"-"`
+	Column       int     `toml:"-"`
+	StdoutOutput *Output `toml:"-"`
+	StderrOutput *Output `toml:"-"`
+	// RetryConditions are the parsed RetryOn entries.
+	RetryConditions []RetryCondition `toml:"-"`
+	// FileEnv holds the variables loaded from EnvFile.
+	FileEnv map[string]string `toml:"-"`
+	// ThenTemplate and RoutingKeyTemplate are compiled when Template is enabled.
+	ThenTemplate       *template.Template `toml:"-"`
+	RoutingKeyTemplate *template.Template `toml:"-"`
+	// Program is the compiled Script or ScriptFile.
+	Program *script.Program `toml:"-"`
+	// Condition is the compiled If expression.
+	Condition *condition.Condition `toml:"-"`
+	// Fingerprint identifies the definition of the handler across reloads.
+	Fingerprint string `toml:"-"`
+	// scriptSource is the contents of ScriptFile.
+	scriptSource string
+	// expandErr is set when the template or the variables of the handler
+	// couldn't be applied, the handler is not validated then.
+	expandErr bool
 }
 
 func Load(configPath string) (*RawConfig, error) {
@@ -92,43 +298,66 @@ func Load(configPath string) (*RawConfig, error) {
 
 	logrus.WithFields(logrus.Fields{"abs": absConfig}).Debug("Found absolute config path")
 
-	// nolint:gosec
-	contents, err := os.ReadFile(absConfig)
+	config, err := decodeFile(absConfig)
 	if err != nil {
-		return nil, fmt.Errorf("cant read config file %s: %w", absConfig, err)
+		return nil, err
 	}
-	logrus.Debugf("Config contents: %s", contents)
 
-	var config RawConfig
-	if _, err := toml.DecodeFile(configPath, &config); err != nil {
-		return nil, fmt.Errorf("failed to decode TOML: %w", err)
+	config.Dir = filepath.Dir(absConfig)
+	if err := config.loadIncludes(); err != nil {
+		return nil, fmt.Errorf("cant load includes: %w", err)
 	}
 
-	if err := config.Validate(); err != nil {
-		return nil, fmt.Errorf("invalid configuration: %w", err)
+	// Expansion and validation go on past the first error so that all the
+	// problems are reported at once.
+	var found diagnostics
+	found.add(config.expand())
+	found.add(config.Validate())
+	if found.hasErrors() {
+		found = append(found, config.warnings...)
+		return nil, fmt.Errorf("invalid configuration: %w", found.err())
 	}
 
-	config.Dir = filepath.Dir(absConfig)
-
 	logrus.Debug("Config is valid")
 
-	return &config, nil
+	return config, nil
 }
 
 func (r *RawConfig) Validate() error {
 	if len(r.Events) == 0 {
-		return errors.New("at least one event handler must be configured")
+		return &ValidationError{Diagnostics: []Diagnostic{{
+			Severity: SeverityError,
+			File:     r.general.File,
+			Message:  "at least one event handler must be configured",
+		}}}
 	}
 
+	var found diagnostics
+	names := map[string]int{}
 	for i, event := range r.Events {
-		if err := event.Validate(); err != nil {
-			return fmt.Errorf("event %d validation failed: %w", i, err)
+		event.Index = i
+		if event.expandErr {
+			continue
+		}
+		if err := event.validate(r.Dir); err != nil {
+			found.add(event.validationError(err))
+			continue
+		}
+		if event.Name == nil {
+			continue
 		}
+		if previous, ok := names[*event.Name]; ok {
+			found.add(event.validationError(fmt.Errorf("name is already used by event %d", previous)))
+			continue
+		}
+		names[*event.Name] = i
 	}
 
 	r.OnEvents = make(map[string][]*Event)
+	r.fingerprints = make(map[string]bool)
 	for _, event := range r.Events {
 		r.OnEvents[event.On] = append(r.OnEvents[event.On], event)
+		r.fingerprints[event.Fingerprint] = true
 	}
 
 	r.EventKeys = make([]string, 0, len(r.OnEvents))
@@ -141,10 +370,29 @@ func (r *RawConfig) Validate() error {
 	}
 
 	if err := r.General.Validate(); err != nil {
-		return fmt.Errorf("general section validation failed: %w", err)
+		diagnostic := r.general
+		diagnostic.Severity = SeverityError
+		diagnostic.Message = fmt.Sprintf("general section validation failed: %v", err)
+		found = append(found, diagnostic)
+	}
+	found.add(r.validateProfiles())
+	if r.General.SessionEnvFile != nil {
+		r.General.SessionEnvFile = utils.JustPtr(resolvePath(r.Dir, *r.General.SessionEnvFile))
 	}
 
-	return nil
+	return found.err()
+}
+
+// validate checks the handler and resolves everything that depends on the
+// config directory.
+func (r *Event) validate(dir string) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+	if err := r.resolvePaths(dir); err != nil {
+		return err
+	}
+	return r.fingerprint()
 }
 
 func (r *GeneralSection) Validate() error {
@@ -157,18 +405,92 @@ func (r *GeneralSection) Validate() error {
 	if r.HotReloadDebounceTimer == nil {
 		r.HotReloadDebounceTimer = utils.JustPtr(time.Second)
 	}
+	if r.MaxOutputSize != nil && *r.MaxOutputSize <= 0 {
+		return errors.New("max_output_size must be positive")
+	}
+	if r.MaxOutputSize == nil {
+		r.MaxOutputSize = utils.JustPtr(defaultMaxOutputSize)
+	}
+	if r.OnReloadError != nil && *r.OnReloadError == "" {
+		return errors.New("on_reload_error can't be empty")
+	}
+	if r.Workers != nil && *r.Workers <= 0 {
+		return errors.New("workers must be positive")
+	}
+	if r.Workers == nil {
+		r.Workers = utils.JustPtr(defaultWorkers)
+	}
+	if r.QueueSize != nil && *r.QueueSize < 0 {
+		return errors.New("queue_size can't be negative")
+	}
+	if r.QueueSize == nil {
+		r.QueueSize = utils.JustPtr(defaultQueueSize)
+	}
+	if r.DryRun == nil {
+		r.DryRun = utils.JustPtr(false)
+	}
+	if r.SessionEnv == nil {
+		r.SessionEnv = utils.JustPtr(SessionEnvDaemon)
+	}
+	switch *r.SessionEnv {
+	case SessionEnvDaemon, SessionEnvHyprland:
+		if r.SessionEnvFile != nil {
+			return fmt.Errorf("session_env_file is only allowed with session_env = %q", SessionEnvFile)
+		}
+	case SessionEnvFile:
+		if r.SessionEnvFile == nil {
+			return errors.New("session_env_file has to be set")
+		}
+	default:
+		return fmt.Errorf("session_env has to be one of %q, %q or %q", SessionEnvDaemon, SessionEnvHyprland, SessionEnvFile)
+	}
 	return nil
 }
 
+// Label identifies the handler in logs, outputs and the job environment,
+// it is the name of the handler or `handler-<index>` for anonymous handlers.
+func (r *Event) Label() string {
+	if r.Name != nil {
+		return *r.Name
+	}
+	return handlerID(r.Index)
+}
+
+func (r *Event) describe() string {
+	if r.Name != nil {
+		return fmt.Sprintf("%d (%s)", r.Index, *r.Name)
+	}
+	return strconv.Itoa(r.Index)
+}
+
+// validationError points err at the handler and, when known, its location.
+func (r *Event) validationError(err error) error {
+	message := fmt.Sprintf("event %s validation failed: %v", r.describe(), err)
+	return &ValidationError{Diagnostics: []Diagnostic{r.diagnostic(SeverityError, message)}}
+}
+
+// HasTag tells whether the handler is tagged with the tag.
+func (r *Event) HasTag(tag string) bool {
+	return slices.Contains(r.Tags, tag)
+}
+
 func (r *Event) Validate() error {
+	if r.Name != nil && !namePattern.MatchString(*r.Name) {
+		return fmt.Errorf("name %q is invalid, it has to match %s", *r.Name, namePattern)
+	}
+	for _, tag := range r.Tags {
+		if tag == "" {
+			return errors.New("tags can't be empty")
+		}
+	}
 	if r.On == "" {
 		return errors.New("'on' field is required")
 	}
 	if r.When == "" {
 		return errors.New("'when' field is required")
 	}
-	if r.Then == "" {
-		return errors.New("'then' field is required")
+	if err := r.validateAction(); err != nil {
+		return err
 	}
 	if r.Timeout != nil && *r.Timeout <= 0 {
 		return errors.New("timeout must be positive")
@@ -176,5 +498,258 @@ func (r *Event) Validate() error {
 	if _, err := regexp.Compile(r.When); err != nil {
 		return fmt.Errorf("regexp expression is invalid: %w", err)
 	}
+	if r.If != nil {
+		compiled, err := condition.Compile(*r.If)
+		if err != nil {
+			return fmt.Errorf("'if' expression is invalid: %w", err)
+		}
+		r.Condition = compiled
+	}
+	if r.Retries != nil && *r.Retries < 0 {
+		return errors.New("retries must be >= 0")
+	}
+	if r.RetryBackoff != nil && *r.RetryBackoff <= 0 {
+		return errors.New("retry_backoff must be positive")
+	}
+	if r.RetryBackoff == nil {
+		r.RetryBackoff = utils.JustPtr(100 * time.Millisecond)
+	}
+	r.RetryConditions = nil
+	for _, condition := range r.RetryOn {
+		parsed, err := parseRetryCondition(condition)
+		if err != nil {
+			return fmt.Errorf("retry_on is invalid: %w", err)
+		}
+		r.RetryConditions = append(r.RetryConditions, parsed)
+	}
+	if err := r.validateCheck(); err != nil {
+		return err
+	}
+	if r.OnReload == nil {
+		r.OnReload = utils.JustPtr(OnReloadRun)
+	}
+	if *r.OnReload != OnReloadRun && *r.OnReload != OnReloadCancel {
+		return fmt.Errorf("on_reload has to be one of %q or %q", OnReloadRun, OnReloadCancel)
+	}
+	if r.MaxOutputSize != nil && *r.MaxOutputSize <= 0 {
+		return errors.New("max_output_size must be positive")
+	}
+	if len(r.EnvAllowlist) > 0 && r.InheritsEnv() {
+		return errors.New("env_allowlist requires inherit_env = false")
+	}
+	for _, pattern := range r.EnvAllowlist {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("env_allowlist pattern %q is invalid: %w", pattern, err)
+		}
+	}
+	if err := r.compileTemplates(); err != nil {
+		return err
+	}
+	if r.Stdin != nil && *r.Stdin != StdinNone && *r.Stdin != StdinJSON {
+		return fmt.Errorf("stdin has to be one of %q or %q", StdinNone, StdinJSON)
+	}
+	if r.Stdout == nil {
+		r.Stdout = utils.JustPtr(defaultOutput)
+	}
+	if r.Stderr == nil {
+		r.Stderr = utils.JustPtr(defaultOutput)
+	}
+	var err error
+	if r.StdoutOutput, err = ParseOutput(*r.Stdout, r.Label()); err != nil {
+		return fmt.Errorf("stdout is invalid: %w", err)
+	}
+	if r.StderrOutput, err = ParseOutput(*r.Stderr, r.Label()); err != nil {
+		return fmt.Errorf("stderr is invalid: %w", err)
+	}
+	return nil
+}
+
+func (r *Event) validateCheck() error {
+	if r.Check == nil {
+		if r.CheckTimeout != nil || r.CheckCacheTTL != nil {
+			return errors.New("check_timeout and check_cache_ttl require check")
+		}
+		return nil
+	}
+	if *r.Check == "" {
+		return errors.New("check can't be empty")
+	}
+	if r.CheckTimeout != nil && *r.CheckTimeout <= 0 {
+		return errors.New("check_timeout must be positive")
+	}
+	if r.CheckTimeout == nil {
+		r.CheckTimeout = utils.JustPtr(defaultCheckTimeout)
+	}
+	if r.CheckCacheTTL != nil && *r.CheckCacheTTL < 0 {
+		return errors.New("check_cache_ttl must be >= 0")
+	}
+	return nil
+}
+
+// validateAction checks that exactly one of the actions is configured.
+func (r *Event) validateAction() error {
+	actions := 0
+	for _, set := range []bool{r.Then != "", len(r.Steps) > 0, r.Coprocess != nil, r.Script != nil, r.ScriptFile != nil} {
+		if set {
+			actions++
+		}
+	}
+	if actions == 0 {
+		return errors.New("'then' field is required")
+	}
+	if actions > 1 {
+		return errors.New("'then', 'steps', 'coprocess', 'script' and 'script_file' are mutually exclusive")
+	}
+	for i := range r.Steps {
+		if err := r.Steps[i].Validate(); err != nil {
+			return fmt.Errorf("step %d is invalid: %w", i, err)
+		}
+	}
+	if r.Coprocess != nil {
+		return r.validateCoprocess()
+	}
+	if r.Script == nil && r.ScriptFile == nil {
+		return nil
+	}
+	if r.TemplateEnabled() {
+		return errors.New("'template' is not supported for script handlers")
+	}
+	if r.Stdin != nil && *r.Stdin != StdinNone {
+		return errors.New("'stdin' is not supported for script handlers")
+	}
+	if r.Script != nil {
+		return r.compileScript(r.Label(), *r.Script)
+	}
 	return nil
 }
+
+func (r *Event) compileScript(name, source string) error {
+	program, err := script.Compile(name, source)
+	if err != nil {
+		return fmt.Errorf("script is invalid: %w", err)
+	}
+	r.Program = program
+	return nil
+}
+
+func (r *Event) validateCoprocess() error {
+	if *r.Coprocess == "" {
+		return errors.New("'coprocess' can't be empty")
+	}
+	if r.TemplateEnabled() {
+		return errors.New("'template' is not supported for coprocess handlers")
+	}
+	if r.Stdin != nil && *r.Stdin != StdinJSON {
+		return errors.New("coprocess handlers always receive events as json on stdin")
+	}
+	// The responses of a coprocess are its results, so they are logged at info.
+	if r.Stdout == nil {
+		r.Stdout = utils.JustPtr(coprocessStdout)
+	}
+	if r.Stderr == nil {
+		r.Stderr = utils.JustPtr(coprocessStderr)
+	}
+	return nil
+}
+
+// TemplateEnabled tells whether `then`, `steps` and `routing_key` are Go templates.
+func (r *Event) TemplateEnabled() bool {
+	return r.Template != nil && *r.Template
+}
+
+func (r *Event) compileTemplates() error {
+	if !r.TemplateEnabled() {
+		return nil
+	}
+	var err error
+	if r.Then != "" {
+		if r.ThenTemplate, err = tmpl.Parse("then", r.Then); err != nil {
+			return fmt.Errorf("'then' template is invalid: %w", err)
+		}
+	}
+	for i := range r.Steps {
+		name := fmt.Sprintf("steps[%d]", i)
+		if r.Steps[i].RunTemplate, err = tmpl.Parse(name, r.Steps[i].Run); err != nil {
+			return fmt.Errorf("'%s' template is invalid: %w", name, err)
+		}
+	}
+	if r.RoutingKey == nil {
+		return nil
+	}
+	if r.RoutingKeyTemplate, err = tmpl.Parse("routing_key", *r.RoutingKey); err != nil {
+		return fmt.Errorf("'routing_key' template is invalid: %w", err)
+	}
+	return nil
+}
+
+// resolvePaths makes workdir absolute (defaulting to the config dir), loads
+// the script and env files, all relative to the config dir.
+func (r *Event) resolvePaths(dir string) error {
+	workdir := dir
+	if r.Workdir != nil {
+		workdir = resolvePath(dir, *r.Workdir)
+	}
+	fi, err := os.Stat(workdir)
+	if err != nil {
+		return fmt.Errorf("workdir is invalid: %w", err)
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("workdir %s is not a directory", workdir)
+	}
+	r.Workdir = &workdir
+
+	if r.ScriptFile != nil {
+		scriptFile := resolvePath(dir, *r.ScriptFile)
+		r.ScriptFile = &scriptFile
+		// nolint:gosec
+		source, err := os.ReadFile(scriptFile)
+		if err != nil {
+			return fmt.Errorf("script_file is invalid: %w", err)
+		}
+		if err := r.compileScript(scriptFile, string(source)); err != nil {
+			return err
+		}
+		r.scriptSource = string(source)
+	}
+
+	if r.EnvFile == nil {
+		return nil
+	}
+	envFile := resolvePath(dir, *r.EnvFile)
+	r.EnvFile = &envFile
+	env, err := utils.ParseEnvFile(envFile)
+	if err != nil {
+		return fmt.Errorf("env_file is invalid: %w", err)
+	}
+	r.FileEnv = env
+	return nil
+}
+
+// InheritsEnv tells whether jobs of the handler start from the daemon's environment.
+func (r *Event) InheritsEnv() bool {
+	return r.InheritEnv == nil || *r.InheritEnv
+}
+
+func resolvePath(dir, target string) string {
+	target = os.ExpandEnv(target)
+	if filepath.IsAbs(target) {
+		return target
+	}
+	return filepath.Join(dir, target)
+}
+
+func parseRetryCondition(condition string) (RetryCondition, error) {
+	if condition == RetryOnTimeout {
+		return RetryCondition{Timeout: true}, nil
+	}
+	code, found := strings.CutPrefix(condition, RetryOnExitPrefix)
+	if !found {
+		return RetryCondition{}, fmt.Errorf("unknown condition %q, expected %q or %q",
+			condition, RetryOnTimeout, RetryOnExitPrefix+"<code>")
+	}
+	exitCode, err := strconv.Atoi(code)
+	if err != nil {
+		return RetryCondition{}, fmt.Errorf("exit code in %q is not a number: %w", condition, err)
+	}
+	return RetryCondition{ExitCode: exitCode}, nil
+}
@@ -0,0 +1,118 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Position is a location in a config file, the column is 0 when unknown.
+type Position struct {
+	Line   int
+	Column int
+}
+
+// Diagnostic is a problem found in the config, pointing at the handler or
+// key that it is about when the position is known.
+type Diagnostic struct {
+	Severity string `json:"severity"`
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+	Message  string `json:"message"`
+}
+
+// Location formats the position as `file:line:column`, leaving out the
+// parts that are not known.
+func (d Diagnostic) Location() string {
+	location := d.File
+	if d.Line > 0 {
+		location += fmt.Sprintf(":%d", d.Line)
+	}
+	if d.Line > 0 && d.Column > 0 {
+		location += fmt.Sprintf(":%d", d.Column)
+	}
+	return location
+}
+
+func (d Diagnostic) String() string {
+	if location := d.Location(); location != "" {
+		return location + ": " + d.Message
+	}
+	return d.Message
+}
+
+// ValidationError holds everything that was found wrong with the config,
+// along with the warnings collected until then.
+type ValidationError struct {
+	Diagnostics []Diagnostic
+}
+
+func (e *ValidationError) Error() string {
+	var messages []string
+	for _, diagnostic := range e.Diagnostics {
+		if diagnostic.Severity == SeverityError {
+			messages = append(messages, diagnostic.String())
+		}
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Diagnostics returns the problems behind the error returned by Load, errors
+// that are not tied to a position become a single diagnostic.
+func Diagnostics(err error) []Diagnostic {
+	if err == nil {
+		return nil
+	}
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		return validationErr.Diagnostics
+	}
+	return []Diagnostic{{Severity: SeverityError, Message: err.Error()}}
+}
+
+// diagnostics collects the problems found while loading the config, so that
+// all of them are reported at once.
+type diagnostics []Diagnostic
+
+func (d *diagnostics) add(err error) {
+	if err != nil {
+		*d = append(*d, Diagnostics(err)...)
+	}
+}
+
+func (d diagnostics) hasErrors() bool {
+	return HasErrors(d)
+}
+
+// HasErrors tells whether any of the diagnostics is an error, warnings alone
+// don't make the config invalid.
+func HasErrors(found []Diagnostic) bool {
+	return slices.ContainsFunc(found, func(diagnostic Diagnostic) bool {
+		return diagnostic.Severity == SeverityError
+	})
+}
+
+func (d diagnostics) err() error {
+	if !d.hasErrors() {
+		return nil
+	}
+	return &ValidationError{Diagnostics: d}
+}
+
+// diagnostic points a message at the definition of the handler.
+func (r *Event) diagnostic(severity, message string) Diagnostic {
+	return Diagnostic{
+		Severity: severity,
+		File:     r.Source,
+		Line:     r.Line,
+		Column:   r.Column,
+		Message:  message,
+	}
+}
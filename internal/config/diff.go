@@ -0,0 +1,116 @@
+package config
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Diff lists the labels of the handlers that changed between two generations.
+type Diff struct {
+	Added    []string
+	Removed  []string
+	Modified []string
+	// Settings is set when the [general] section, the profiles or the order
+	// of the handlers changed.
+	Settings bool
+}
+
+// Empty reports whether the two generations are equivalent.
+func (d *Diff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Modified) == 0 && !d.Settings
+}
+
+// Compare matches named handlers by name and anonymous ones by their
+// definition, so a changed anonymous handler shows up as removed and added.
+func Compare(previous, current *RawConfig) *Diff {
+	diff := &Diff{}
+	before := handlersByKey(previous)
+	after := handlersByKey(current)
+	for _, event := range previous.Events {
+		if _, ok := after[event.key()]; !ok {
+			diff.Removed = append(diff.Removed, event.Label())
+		}
+	}
+	for _, event := range current.Events {
+		old, ok := before[event.key()]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, event.Label())
+		case old.Fingerprint != event.Fingerprint:
+			diff.Modified = append(diff.Modified, event.Label())
+		}
+	}
+	diff.Settings = settingsChanged(previous, current)
+	return diff
+}
+
+// settingsChanged compares everything but the handlers, an encoding error
+// counts as a change.
+func settingsChanged(previous, current *RawConfig) bool {
+	before, err := encodeSettings(previous)
+	if err != nil {
+		return true
+	}
+	after, err := encodeSettings(current)
+	if err != nil {
+		return true
+	}
+	return !bytes.Equal(before, after)
+}
+
+func encodeSettings(cfg *RawConfig) ([]byte, error) {
+	order := make([]string, 0, len(cfg.Events))
+	for _, event := range cfg.Events {
+		order = append(order, event.key())
+	}
+	var buf bytes.Buffer
+	err := toml.NewEncoder(&buf).Encode(map[string]any{
+		"general": cfg.General,
+		"profile": cfg.Profiles,
+		"order":   order,
+	})
+	return buf.Bytes(), err
+}
+
+func handlersByKey(cfg *RawConfig) map[string]*Event {
+	handlers := map[string]*Event{}
+	for _, event := range cfg.Events {
+		handlers[event.key()] = event
+	}
+	return handlers
+}
+
+func (r *Event) key() string {
+	if r.Name != nil {
+		return "name:" + *r.Name
+	}
+	return "fingerprint:" + r.Fingerprint
+}
+
+// HasHandler reports whether a handler with the given fingerprint is configured.
+func (r *RawConfig) HasHandler(fingerprint string) bool {
+	return r.fingerprints[fingerprint]
+}
+
+// fingerprint hashes the user-facing definition of the handler, the derived
+// fields (and the place it is defined at) are skipped. The loaded env_file
+// and script_file are part of the definition, so changing them counts as a
+// change of the handler.
+func (r *Event) fingerprint() error {
+	var buf bytes.Buffer
+	encoder := toml.NewEncoder(&buf)
+	if err := encoder.Encode(r); err != nil {
+		return fmt.Errorf("cant encode handler: %w", err)
+	}
+	if err := encoder.Encode(map[string]any{"env_file": r.FileEnv}); err != nil {
+		return fmt.Errorf("cant encode env file: %w", err)
+	}
+	buf.WriteString(r.scriptSource)
+	sum := sha256.Sum256(buf.Bytes())
+	r.Fingerprint = hex.EncodeToString(sum[:8])
+	return nil
+}
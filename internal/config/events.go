@@ -0,0 +1,64 @@
+package config
+
+// eventFields lists the data fields of the Hyprland IPC events, see
+// https://wiki.hypr.land/IPC/. The data is the comma separated fields.
+var eventFields = map[string][]string{
+	"workspace":          {"WORKSPACENAME"},
+	"workspacev2":        {"WORKSPACEID", "WORKSPACENAME"},
+	"focusedmon":         {"MONNAME", "WORKSPACENAME"},
+	"focusedmonv2":       {"MONNAME", "WORKSPACEID"},
+	"activewindow":       {"WINDOWCLASS", "WINDOWTITLE"},
+	"activewindowv2":     {"WINDOWADDRESS"},
+	"fullscreen":         {"STATE"},
+	"monitorremoved":     {"MONITORNAME"},
+	"monitorremovedv2":   {"MONITORID", "MONITORNAME", "MONITORDESCRIPTION"},
+	"monitoradded":       {"MONITORNAME"},
+	"monitoraddedv2":     {"MONITORID", "MONITORNAME", "MONITORDESCRIPTION"},
+	"createworkspace":    {"WORKSPACENAME"},
+	"createworkspacev2":  {"WORKSPACEID", "WORKSPACENAME"},
+	"destroyworkspace":   {"WORKSPACENAME"},
+	"destroyworkspacev2": {"WORKSPACEID", "WORKSPACENAME"},
+	"moveworkspace":      {"WORKSPACENAME", "MONNAME"},
+	"moveworkspacev2":    {"WORKSPACEID", "WORKSPACENAME", "MONNAME"},
+	"renameworkspace":    {"WORKSPACEID", "NEWNAME"},
+	"activespecial":      {"WORKSPACENAME", "MONNAME"},
+	"activespecialv2":    {"WORKSPACEID", "WORKSPACENAME", "MONNAME"},
+	"activelayout":       {"KEYBOARDNAME", "LAYOUTNAME"},
+	"openwindow":         {"WINDOWADDRESS", "WORKSPACENAME", "WINDOWCLASS", "WINDOWTITLE"},
+	"closewindow":        {"WINDOWADDRESS"},
+	"kill":               {"WINDOWADDRESS"},
+	"movewindow":         {"WINDOWADDRESS", "WORKSPACENAME"},
+	"movewindowv2":       {"WINDOWADDRESS", "WORKSPACEID", "WORKSPACENAME"},
+	"openlayer":          {"NAMESPACE"},
+	"closelayer":         {"NAMESPACE"},
+	"submap":             {"SUBMAPNAME"},
+	"changefloatingmode": {"WINDOWADDRESS", "FLOATING"},
+	"urgent":             {"WINDOWADDRESS"},
+	"screencast":         {"STATE", "OWNER"},
+	"windowtitle":        {"WINDOWADDRESS"},
+	"windowtitlev2":      {"WINDOWADDRESS", "WINDOWTITLE"},
+	"togglegroup":        {"STATE", "WINDOWADDRESSES"},
+	"moveintogroup":      {"WINDOWADDRESS"},
+	"moveoutofgroup":     {"WINDOWADDRESS"},
+	"ignoregrouplock":    {"STATE"},
+	"lockgroups":         {"STATE"},
+	"configreloaded":     {},
+	"pin":                {"WINDOWADDRESS", "PINSTATE"},
+	"minimized":          {"WINDOWADDRESS", "STATE"},
+	"bell":               {"WINDOWADDRESS"},
+}
+
+// freeTextFields are the fields that can contain commas themselves, so the
+// events that have them can carry more commas than fields.
+var freeTextFields = map[string]bool{
+	"WORKSPACENAME":      true,
+	"NEWNAME":            true,
+	"WINDOWCLASS":        true,
+	"WINDOWTITLE":        true,
+	"MONITORDESCRIPTION": true,
+	"LAYOUTNAME":         true,
+	"KEYBOARDNAME":       true,
+	"NAMESPACE":          true,
+	"SUBMAPNAME":         true,
+	"WINDOWADDRESSES":    true,
+}
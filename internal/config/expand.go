@@ -0,0 +1,119 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"regexp"
+	"slices"
+)
+
+// varReference matches `${var.<name>}`, the dot keeps it apart from shell
+// parameter expansion.
+var varReference = regexp.MustCompile(`\$\{var\.([A-Za-z0-9_-]*)\}`)
+
+// expand applies the templates that handlers extend and then substitutes the
+// variables, so that the handlers are complete before validation. Handlers
+// that can't be expanded are marked so that they are not validated.
+func (r *RawConfig) expand() error {
+	var found diagnostics
+	templates := map[string]*Event{}
+	for _, template := range r.Templates {
+		if template.Name == nil || *template.Name == "" {
+			found = append(found, template.diagnostic(SeverityError, "template requires a name"))
+			continue
+		}
+		if template.Extends != nil {
+			found = append(found, template.diagnostic(SeverityError,
+				fmt.Sprintf("template %s can't extend other templates", *template.Name)))
+			continue
+		}
+		if _, ok := templates[*template.Name]; ok {
+			found = append(found, template.diagnostic(SeverityError,
+				fmt.Sprintf("template %s is defined more than once", *template.Name)))
+			continue
+		}
+		templates[*template.Name] = template
+	}
+
+	for i, event := range r.Events {
+		event.Index = i
+		if event.Extends != nil {
+			template, ok := templates[*event.Extends]
+			if !ok {
+				found.add(event.validationError(fmt.Errorf("extends an unknown template %q", *event.Extends)))
+				event.expandErr = true
+				continue
+			}
+			applyTemplate(event, template)
+		}
+		if err := r.substituteVars(event); err != nil {
+			found.add(event.validationError(err))
+			event.expandErr = true
+		}
+	}
+	return found.err()
+}
+
+// actionFields are the mutually exclusive fields that define what a handler
+// does, they are inherited from a template as a whole.
+var actionFields = []string{"then", "steps", "coprocess", "script", "script_file"}
+
+// applyTemplate sets the fields that the handler leaves unset to the ones of
+// the template, the env tables are merged with the handler taking precedence.
+// The action of the template is only inherited when the handler sets none.
+func applyTemplate(event, template *Event) {
+	env := maps.Clone(template.Env)
+	dst := reflect.ValueOf(event).Elem()
+	src := reflect.ValueOf(template).Elem()
+	hasAction := false
+	for i := range dst.NumField() {
+		tag := dst.Type().Field(i).Tag.Get("toml")
+		if slices.Contains(actionFields, tag) && !dst.Field(i).IsZero() {
+			hasAction = true
+		}
+	}
+	for i := range dst.NumField() {
+		tag := dst.Type().Field(i).Tag.Get("toml")
+		if tag == "" || tag == "-" || tag == "name" || tag == "extends" {
+			continue
+		}
+		if hasAction && slices.Contains(actionFields, tag) {
+			continue
+		}
+		if dst.Field(i).IsZero() {
+			dst.Field(i).Set(src.Field(i))
+		}
+	}
+	// The steps are compiled in place during validation.
+	event.Steps = slices.Clone(event.Steps)
+	if env != nil && event.Env != nil {
+		maps.Copy(env, event.Env)
+		event.Env = env
+	}
+	event.Extends = nil
+}
+
+// substituteVars replaces the `${var.<name>}` references in the regex, the
+// command and the routing key.
+func (r *RawConfig) substituteVars(event *Event) error {
+	var errs []error
+	substitute := func(value string) string {
+		return varReference.ReplaceAllStringFunc(value, func(reference string) string {
+			name := varReference.FindStringSubmatch(reference)[1]
+			value, ok := r.Vars[name]
+			if !ok {
+				errs = append(errs, fmt.Errorf("variable %q is not defined", name))
+			}
+			return value
+		})
+	}
+	event.When = substitute(event.When)
+	event.Then = substitute(event.Then)
+	if event.RoutingKey != nil {
+		routingKey := substitute(*event.RoutingKey)
+		event.RoutingKey = &routingKey
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,231 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	FormatTOML = "toml"
+	FormatYAML = "yaml"
+	FormatJSON = "json"
+)
+
+// DetectFormat picks the format from the file extension, files with other
+// extensions are read as TOML.
+func DetectFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".json":
+		return FormatJSON
+	default:
+		return FormatTOML
+	}
+}
+
+// ParseFormat validates a user provided format name.
+func ParseFormat(format string) (string, error) {
+	switch strings.ToLower(format) {
+	case FormatTOML:
+		return FormatTOML, nil
+	case FormatYAML, "yml":
+		return FormatYAML, nil
+	case FormatJSON:
+		return FormatJSON, nil
+	default:
+		return "", fmt.Errorf("format has to be one of %q, %q or %q", FormatTOML, FormatYAML, FormatJSON)
+	}
+}
+
+// DecodeDocument parses the contents into a generic document, the numbers
+// are normalized to int64 and float64 regardless of the format.
+func DecodeDocument(contents []byte, format string) (map[string]any, error) {
+	doc := map[string]any{}
+	switch format {
+	case FormatTOML:
+		if _, err := toml.Decode(string(contents), &doc); err != nil {
+			return nil, fmt.Errorf("failed to decode TOML: %w", err)
+		}
+	case FormatYAML:
+		if err := yaml.Unmarshal(contents, &doc); err != nil {
+			return nil, fmt.Errorf("failed to decode YAML: %w", err)
+		}
+	case FormatJSON:
+		decoder := json.NewDecoder(bytes.NewReader(contents))
+		decoder.UseNumber()
+		if err := decoder.Decode(&doc); err != nil {
+			return nil, fmt.Errorf("failed to decode JSON: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported format %q", format)
+	}
+	normalized, err := normalize(doc)
+	if err != nil {
+		return nil, err
+	}
+	// nolint:forcetypeassert
+	return normalized.(map[string]any), nil
+}
+
+// EncodeDocument writes the generic document in the given format.
+func EncodeDocument(doc map[string]any, format string) ([]byte, error) {
+	var buf bytes.Buffer
+	switch format {
+	case FormatTOML:
+		encoder := toml.NewEncoder(&buf)
+		encoder.Indent = ""
+		if err := encoder.Encode(doc); err != nil {
+			return nil, fmt.Errorf("failed to encode TOML: %w", err)
+		}
+	case FormatYAML:
+		encoder := yaml.NewEncoder(&buf)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(doc); err != nil {
+			return nil, fmt.Errorf("failed to encode YAML: %w", err)
+		}
+	case FormatJSON:
+		encoder := json.NewEncoder(&buf)
+		encoder.SetIndent("", "  ")
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(doc); err != nil {
+			return nil, fmt.Errorf("failed to encode JSON: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported format %q", format)
+	}
+	return buf.Bytes(), nil
+}
+
+// Convert translates a config document, the result is decoded again and
+// compared with the input so that nothing is lost on the way.
+func Convert(contents []byte, from, to string) ([]byte, error) {
+	doc, err := DecodeDocument(contents, from)
+	if err != nil {
+		return nil, err
+	}
+	converted, err := EncodeDocument(doc, to)
+	if err != nil {
+		return nil, err
+	}
+	roundTrip, err := DecodeDocument(converted, to)
+	if err != nil {
+		return nil, fmt.Errorf("cant decode the converted config: %w", err)
+	}
+	if !reflect.DeepEqual(doc, roundTrip) {
+		return nil, fmt.Errorf("config can't be represented in %s without losing data", to)
+	}
+	return converted, nil
+}
+
+// normalize converts the values decoded by the different libraries to a
+// common set of types; null values are dropped as they mean unset.
+func normalize(value any) (any, error) {
+	switch v := value.(type) {
+	case map[string]any:
+		normalized := make(map[string]any, len(v))
+		for key, item := range v {
+			if item == nil {
+				continue
+			}
+			n, err := normalize(item)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+			normalized[key] = n
+		}
+		return normalized, nil
+	case map[any]any:
+		converted := make(map[string]any, len(v))
+		for key, item := range v {
+			name, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("key %v has to be a string", key)
+			}
+			converted[name] = item
+		}
+		return normalize(converted)
+	case []map[string]any:
+		items := make([]any, len(v))
+		for i, item := range v {
+			items[i] = item
+		}
+		return normalize(items)
+	case []any:
+		normalized := make([]any, 0, len(v))
+		for i, item := range v {
+			if item == nil {
+				return nil, fmt.Errorf("item %d can't be null", i)
+			}
+			n, err := normalize(item)
+			if err != nil {
+				return nil, fmt.Errorf("item %d: %w", i, err)
+			}
+			normalized = append(normalized, n)
+		}
+		return normalized, nil
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s: %w", v, err)
+		}
+		return f, nil
+	case int:
+		return int64(v), nil
+	case uint64:
+		if v > 1<<63-1 {
+			return nil, errors.New("number is too large")
+		}
+		return int64(v), nil
+	case float32:
+		return float64(v), nil
+	default:
+		return v, nil
+	}
+}
+
+// yamlKeyPositions indexes the keys of a YAML document the same way as
+// keyPositions does for TOML.
+func yamlKeyPositions(contents []byte) map[string][]Position {
+	var root yaml.Node
+	if err := yaml.Unmarshal(contents, &root); err != nil || len(root.Content) == 0 {
+		return nil
+	}
+	positions := map[string][]Position{}
+	var walk func(node *yaml.Node, path string)
+	walk = func(node *yaml.Node, path string) {
+		switch node.Kind {
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				key, value := node.Content[i], node.Content[i+1]
+				keyPath := joinKey(path, key.Value)
+				if value.Kind == yaml.SequenceNode && len(value.Content) > 0 && value.Content[0].Kind == yaml.MappingNode {
+					for _, item := range value.Content {
+						positions[keyPath] = append(positions[keyPath], Position{Line: item.Line, Column: item.Column})
+						walk(item, keyPath)
+					}
+					continue
+				}
+				positions[keyPath] = append(positions[keyPath], Position{Line: key.Line, Column: key.Column})
+				walk(value, keyPath)
+			}
+		case yaml.SequenceNode:
+			for _, item := range node.Content {
+				walk(item, path)
+			}
+		}
+	}
+	walk(root.Content[0], "")
+	return positions
+}
@@ -0,0 +1,341 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// tableHeader matches the `[table]` and `[[array]]` headers.
+	tableHeader = regexp.MustCompile(`^(\s*)\[\[?([^\[\]]+)\]\]?`)
+	// keyValue matches the key of a `key = value` line.
+	keyValue = regexp.MustCompile(`^(\s*)([A-Za-z0-9_.\-"' ]+?)\s*=`)
+	// typeError matches the errors of values that don't fit the config
+	// fields, they hold the line in the decoded TOML document and the key.
+	typeError = regexp.MustCompile(`^toml: (?:line (\d+) )?\(last key "([^"]*)"\): (.*)$`)
+)
+
+// decodeFile parses a single config file, the handlers are annotated with the
+// file, line and column that they are defined at.
+func decodeFile(path string) (*RawConfig, error) {
+	// nolint:gosec
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cant read config file %s: %w", path, err)
+	}
+	logrus.Debugf("Config contents of %s: %s", path, contents)
+
+	format := DetectFormat(path)
+	var config RawConfig
+	meta, decoded, err := decodeConfig(contents, format, &config)
+	var parseErr toml.ParseError
+	if format == FormatTOML && errors.As(err, &parseErr) {
+		return nil, &ValidationError{Diagnostics: []Diagnostic{{
+			Severity: SeverityError,
+			File:     path,
+			Line:     parseErr.Position.Line,
+			Column:   parseErr.Position.Col,
+			Message:  "cant decode config file: " + parseErr.Message,
+		}}}
+	}
+	if diagnostic, ok := typeErrorDiagnostic(path, contents, decoded, format, err); ok {
+		return nil, &ValidationError{Diagnostics: []Diagnostic{diagnostic}}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cant decode config file %s: %w", path, err)
+	}
+
+	positions := keyPositions(contents, format)
+	for i, event := range config.Events {
+		event.Source = path
+		if i < len(positions["handler"]) {
+			event.Line = positions["handler"][i].Line
+			event.Column = positions["handler"][i].Column
+		}
+	}
+	for i, template := range config.Templates {
+		template.Source = path
+		if i < len(positions["template"]) {
+			template.Line = positions["template"][i].Line
+			template.Column = positions["template"][i].Column
+		}
+	}
+	config.warnings = unknownKeys(path, meta, positions)
+	config.general = Diagnostic{File: path}
+	if general := positions["general"]; len(general) > 0 {
+		config.general.Line = general[0].Line
+		config.general.Column = general[0].Column
+	}
+	config.Files = []string{path}
+	config.Format = format
+	return &config, nil
+}
+
+// decodeConfig decodes the file into the config, YAML and JSON documents are
+// translated to TOML first so that all formats are decoded the same way. The
+// decoded TOML document is returned as well.
+func decodeConfig(contents []byte, format string, config *RawConfig) (toml.MetaData, []byte, error) {
+	if format != FormatTOML {
+		doc, err := DecodeDocument(contents, format)
+		if err != nil {
+			return toml.MetaData{}, nil, err
+		}
+		if contents, err = EncodeDocument(doc, FormatTOML); err != nil {
+			return toml.MetaData{}, nil, err
+		}
+	}
+	meta, err := toml.Decode(string(contents), config)
+	if err != nil {
+		return meta, contents, fmt.Errorf("failed to decode %s: %w", strings.ToUpper(format), err)
+	}
+	return meta, contents, nil
+}
+
+// typeErrorDiagnostic reports a value that doesn't fit its field at the key
+// in the original document, for YAML and JSON the error points at the
+// translated TOML document otherwise.
+func typeErrorDiagnostic(path string, contents, decoded []byte, format string, err error) (Diagnostic, bool) {
+	if err == nil {
+		return Diagnostic{}, false
+	}
+	match := typeError.FindStringSubmatch(errors.Unwrap(err).Error())
+	if match == nil {
+		return Diagnostic{}, false
+	}
+	key, message := match[2], strings.Replace(match[3], "TOML value", "value", 1)
+	diagnostic := Diagnostic{
+		Severity: SeverityError,
+		File:     path,
+		Message:  fmt.Sprintf("cant decode config file: %s: %s", key, message),
+	}
+	line, _ := strconv.Atoi(match[1])
+	// Keys of array tables repeat, the n-th one in the decoded document is
+	// the n-th one in the original.
+	n := slices.IndexFunc(keyPositions(decoded, FormatTOML)[key], func(p Position) bool { return p.Line == line })
+	if original := keyPositions(contents, format)[key]; n >= 0 && n < len(original) {
+		diagnostic.Line = original[n].Line
+		diagnostic.Column = original[n].Column
+	}
+	return diagnostic, true
+}
+
+// unknownKeys warns about the keys that don't map to any config field, they
+// are most likely typos.
+func unknownKeys(path string, meta toml.MetaData, positions map[string][]Position) []Diagnostic {
+	var warnings []Diagnostic
+	seen := map[string]int{}
+	unknown := map[string]bool{}
+	for _, key := range meta.Undecoded() {
+		name := strings.Join(key, ".")
+		// Keys of array tables repeat, the n-th one is at the n-th position.
+		n := seen[name]
+		seen[name]++
+		// Editors read the location of the schema from the document.
+		if name == "$schema" {
+			continue
+		}
+		unknown[name] = true
+		// The keys of an unknown table are not reported on their own.
+		if unknown[strings.Join(key[:len(key)-1], ".")] {
+			continue
+		}
+		warning := Diagnostic{Severity: SeverityWarning, File: path, Message: fmt.Sprintf("unknown key %q", name)}
+		if n < len(positions[name]) {
+			warning.Line = positions[name][n].Line
+			warning.Column = positions[name][n].Column
+		}
+		warnings = append(warnings, warning)
+	}
+	return warnings
+}
+
+// keyPositions returns the positions of the keys and tables, by the dotted
+// path of the key without the array indexes. The items of arrays of tables
+// are recorded under the path of the array. JSON documents are not indexed.
+func keyPositions(contents []byte, format string) map[string][]Position {
+	if format == FormatYAML {
+		return yamlKeyPositions(contents)
+	}
+	if format != FormatTOML {
+		return nil
+	}
+	positions := map[string][]Position{}
+	table := ""
+	multiline := false
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		// Lines of multi-line strings can look like keys.
+		wasMultiline := multiline
+		if (strings.Count(text, `"""`)+strings.Count(text, "'''"))%2 == 1 {
+			multiline = !multiline
+		}
+		if wasMultiline {
+			continue
+		}
+		if match := tableHeader.FindStringSubmatch(text); match != nil {
+			table = normalizeKey(match[2])
+			positions[table] = append(positions[table], Position{Line: line, Column: len(match[1]) + 1})
+			continue
+		}
+		if match := keyValue.FindStringSubmatch(text); match != nil {
+			key := joinKey(table, normalizeKey(match[2]))
+			positions[key] = append(positions[key], Position{Line: line, Column: len(match[1]) + 1})
+		}
+	}
+	return positions
+}
+
+// normalizeKey drops the quotes and the whitespace around the parts of a
+// dotted key.
+func normalizeKey(key string) string {
+	parts := strings.Split(key, ".")
+	for i, part := range parts {
+		parts[i] = strings.Trim(strings.TrimSpace(part), `"'`)
+	}
+	return strings.Join(parts, ".")
+}
+
+func joinKey(table, key string) string {
+	if table == "" {
+		return key
+	}
+	return table + "." + key
+}
+
+// loadIncludes merges the included files into the config, in the order of
+// the include patterns (and lexical order within a glob). The [general]
+// fields set in included files override the ones loaded before them,
+// handlers are appended.
+func (r *RawConfig) loadIncludes() error {
+	for _, pattern := range r.Include {
+		resolved := resolvePath(r.Dir, pattern)
+		matches, err := filepath.Glob(resolved)
+		if err != nil {
+			return fmt.Errorf("include pattern %q is invalid: %w", pattern, err)
+		}
+		if len(matches) == 0 && !hasGlobMeta(resolved) {
+			return fmt.Errorf("included file %s not found", resolved)
+		}
+		if dir := filepath.Dir(resolved); !hasGlobMeta(dir) {
+			r.includeDirs = append(r.includeDirs, dir)
+		}
+		r.includePatterns = append(r.includePatterns, resolved)
+
+		for _, match := range matches {
+			fi, err := os.Stat(match)
+			if err != nil {
+				return fmt.Errorf("cant stat included file %s: %w", match, err)
+			}
+			if fi.IsDir() || slices.Contains(r.Files, match) {
+				continue
+			}
+			included, err := decodeFile(match)
+			if err != nil {
+				return err
+			}
+			if len(included.Include) > 0 {
+				return fmt.Errorf("included file %s can't include other files", match)
+			}
+			if included.General != nil {
+				r.general = included.general
+			}
+			r.General = mergeGeneral(r.General, included.General)
+			r.warnings = append(r.warnings, included.warnings...)
+			r.Vars = mergeMap(r.Vars, included.Vars)
+			r.Profiles = mergeMap(r.Profiles, included.Profiles)
+			r.Templates = append(r.Templates, included.Templates...)
+			r.Events = append(r.Events, included.Events...)
+			r.Files = append(r.Files, match)
+		}
+	}
+	return nil
+}
+
+func hasGlobMeta(path string) bool {
+	return strings.ContainsAny(path, `*?[\`)
+}
+
+// mergeGeneral overrides the fields of base with the ones set in override,
+// all the fields of GeneralSection are pointers so nil means unset.
+func mergeGeneral(base, override *GeneralSection) *GeneralSection {
+	if override == nil {
+		return base
+	}
+	if base == nil {
+		return override
+	}
+	merged := *base
+	dst := reflect.ValueOf(&merged).Elem()
+	src := reflect.ValueOf(override).Elem()
+	for i := range src.NumField() {
+		if !src.Field(i).IsNil() {
+			dst.Field(i).Set(src.Field(i))
+		}
+	}
+	return &merged
+}
+
+// mergeMap adds the entries (variables, profiles) of an included file,
+// overriding the ones loaded before it.
+func mergeMap[V any](base, override map[string]V) map[string]V {
+	if base == nil {
+		return override
+	}
+	maps.Copy(base, override)
+	return base
+}
+
+// WatchDirs returns the directories that contain the config files and the
+// env and script files of the handlers, and the ones that include patterns
+// point at, so that new files are picked up.
+func (r *RawConfig) WatchDirs() []string {
+	dirs := slices.Clone(r.includeDirs)
+	for _, file := range r.WatchFiles() {
+		dirs = append(dirs, filepath.Dir(file))
+	}
+	dirs = slices.DeleteFunc(dirs, func(dir string) bool {
+		_, err := os.Stat(dir)
+		return errors.Is(err, os.ErrNotExist)
+	})
+	slices.Sort(dirs)
+	return slices.Compact(dirs)
+}
+
+// MatchesInclude reports whether path matches one of the include patterns,
+// creating or removing such a file changes the config.
+func (r *RawConfig) MatchesInclude(path string) bool {
+	for _, pattern := range r.includePatterns {
+		if matched, err := filepath.Match(pattern, path); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// WatchFiles returns the config files followed by the env and script files
+// of the handlers, a change to any of them is a change to the config.
+func (r *RawConfig) WatchFiles() []string {
+	files := slices.Clone(r.Files)
+	for _, event := range r.Events {
+		for _, file := range []*string{event.EnvFile, event.ScriptFile} {
+			if file != nil && !slices.Contains(files, *file) {
+				files = append(files, *file)
+			}
+		}
+	}
+	return files
+}
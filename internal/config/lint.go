@@ -0,0 +1,229 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/fiffeek/hyprwhenthen/internal/utils"
+)
+
+var (
+	// groupReference matches the capture groups used in routing keys.
+	groupReference = regexp.MustCompile(`\$\{?` + regexGroupPrefix + `([0-9]+)\}?`)
+	// templateGroupReference matches the capture groups used in templates.
+	templateGroupReference = regexp.MustCompile(`index\s+\.Captures\s+([0-9]+)`)
+)
+
+// regexGroupPrefix mirrors the prefix of the capture group variables that
+// jobs receive.
+const regexGroupPrefix = "REGEX_GROUP_"
+
+// unbounded is the comma count of patterns that can match any number of them.
+const unbounded = -1
+
+// Lint looks for mistakes that don't make the config invalid: unknown keys
+// and event types, routing keys that use missing capture groups, regexes that
+// can't match the data of the event and duplicated handlers. The config has
+// to be validated first.
+func (r *RawConfig) Lint() []Diagnostic {
+	warnings := slices.Clone(r.warnings)
+	definitions := map[string]*Event{}
+	for _, event := range r.Events {
+		for _, message := range event.lint() {
+			warnings = append(warnings, event.diagnostic(SeverityWarning, fmt.Sprintf("event %s: %s", event.describe(), message)))
+		}
+		key := event.definitionKey()
+		if original, ok := definitions[key]; ok {
+			warnings = append(warnings, event.diagnostic(SeverityWarning,
+				fmt.Sprintf("event %s: duplicates event %s", event.describe(), original.describe())))
+			continue
+		}
+		definitions[key] = event
+	}
+
+	files := map[string]int{}
+	for i, file := range r.Files {
+		files[file] = i
+	}
+	slices.SortStableFunc(warnings, func(a, b Diagnostic) int {
+		if a.File != b.File {
+			return files[a.File] - files[b.File]
+		}
+		if a.Line != b.Line {
+			return a.Line - b.Line
+		}
+		return a.Column - b.Column
+	})
+	return warnings
+}
+
+func (r *Event) lint() []string {
+	var messages []string
+	fields, known := eventFields[r.On]
+	if !known {
+		messages = append(messages, fmt.Sprintf("%q is not a known Hyprland event", r.On))
+	}
+
+	regex := regexp.MustCompile(r.When)
+	if r.RoutingKey != nil {
+		for _, group := range r.routingKeyGroups() {
+			if group > regex.NumSubexp() {
+				messages = append(messages, fmt.Sprintf("routing_key uses capture group %d but the regex has %d",
+					group, regex.NumSubexp()))
+			}
+		}
+	}
+
+	if known && !matchesArity(r.When, fields) {
+		messages = append(messages, fmt.Sprintf("regex can never match %s events, their data is %s",
+			r.On, strings.Join(fields, ",")))
+	}
+	return messages
+}
+
+// routingKeyGroups returns the capture groups that the routing key uses.
+func (r *Event) routingKeyGroups() []int {
+	reference := groupReference
+	if r.TemplateEnabled() {
+		reference = templateGroupReference
+	}
+	var groups []int
+	for _, match := range reference.FindAllStringSubmatch(*r.RoutingKey, -1) {
+		group, err := strconv.Atoi(match[1])
+		if err == nil {
+			groups = append(groups, group)
+		}
+	}
+	slices.Sort(groups)
+	return slices.Compact(groups)
+}
+
+// definitionKey identifies what the handler reacts to and what it does,
+// handlers that differ only by name, tags or settings have the same key.
+func (r *Event) definitionKey() string {
+	parts := []string{
+		r.On, r.When, utils.Deref(r.If), utils.Deref(r.Profile), r.Then,
+		utils.Deref(r.Coprocess), utils.Deref(r.Script), utils.Deref(r.ScriptFile),
+	}
+	for _, step := range r.Steps {
+		parts = append(parts, step.Run)
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// matchesArity tells whether the regex can match data made of the fields,
+// judging by the number of commas that a match has to or can contain.
+func matchesArity(when string, fields []string) bool {
+	re, err := syntax.Parse(when, syntax.Perl)
+	if err != nil {
+		return true
+	}
+	re = re.Simplify()
+	least, most := commaRange(re)
+
+	commas := max(len(fields)-1, 0)
+	maxCommas := commas
+	if slices.ContainsFunc(fields, func(field string) bool { return freeTextFields[field] }) {
+		maxCommas = unbounded
+	}
+	if maxCommas != unbounded && least > maxCommas {
+		return false
+	}
+	// Only a regex anchored at both ends has to cover all the fields.
+	return !anchored(re) || most == unbounded || most >= commas
+}
+
+func anchored(re *syntax.Regexp) bool {
+	if re.Op != syntax.OpConcat || len(re.Sub) < 2 {
+		return false
+	}
+	first, last := re.Sub[0].Op, re.Sub[len(re.Sub)-1].Op
+	return (first == syntax.OpBeginText || first == syntax.OpBeginLine) &&
+		(last == syntax.OpEndText || last == syntax.OpEndLine)
+}
+
+// commaRange returns the fewest and the most commas that a match of the
+// regex contains.
+func commaRange(re *syntax.Regexp) (int, int) {
+	switch re.Op {
+	case syntax.OpLiteral:
+		commas := strings.Count(string(re.Rune), ",")
+		return commas, commas
+	case syntax.OpCharClass:
+		return classCommas(re.Rune)
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return 0, 1
+	case syntax.OpCapture:
+		return commaRange(re.Sub[0])
+	case syntax.OpConcat:
+		least, most := 0, 0
+		for _, sub := range re.Sub {
+			subLeast, subMost := commaRange(sub)
+			least += subLeast
+			most = addCommas(most, subMost)
+		}
+		return least, most
+	case syntax.OpAlternate:
+		least, most := commaRange(re.Sub[0])
+		for _, sub := range re.Sub[1:] {
+			subLeast, subMost := commaRange(sub)
+			least = min(least, subLeast)
+			if most != unbounded && (subMost == unbounded || subMost > most) {
+				most = subMost
+			}
+		}
+		return least, most
+	case syntax.OpStar:
+		return 0, repeatCommas(re.Sub[0], -1)
+	case syntax.OpPlus:
+		least, _ := commaRange(re.Sub[0])
+		return least, repeatCommas(re.Sub[0], -1)
+	case syntax.OpQuest:
+		_, most := commaRange(re.Sub[0])
+		return 0, most
+	case syntax.OpRepeat:
+		least, _ := commaRange(re.Sub[0])
+		return least * re.Min, repeatCommas(re.Sub[0], re.Max)
+	default:
+		return 0, 0
+	}
+}
+
+// classCommas tells whether a character class has to or can match a comma.
+func classCommas(ranges []rune) (int, int) {
+	onlyComma := len(ranges) == 2 && ranges[0] == ',' && ranges[1] == ','
+	if onlyComma {
+		return 1, 1
+	}
+	for i := 0; i+1 < len(ranges); i += 2 {
+		if ranges[i] <= ',' && ',' <= ranges[i+1] {
+			return 0, 1
+		}
+	}
+	return 0, 0
+}
+
+// repeatCommas returns the most commas of a repetition, times is -1 for
+// unlimited repetitions.
+func repeatCommas(re *syntax.Regexp, times int) int {
+	_, most := commaRange(re)
+	switch {
+	case most == 0:
+		return 0
+	case most == unbounded || times == -1:
+		return unbounded
+	default:
+		return most * times
+	}
+}
+
+func addCommas(a, b int) int {
+	if a == unbounded || b == unbounded {
+		return unbounded
+	}
+	return a + b
+}
@@ -0,0 +1,90 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/fiffeek/hyprwhenthen/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+type OutputKind int
+
+const (
+	OutputDiscard OutputKind = iota
+	OutputLog
+	OutputFile
+	OutputRotate
+)
+
+const (
+	outputDiscard    = "discard"
+	outputLog        = "log"
+	outputFilePrefix = "file:"
+	outputRotate     = "rotate"
+
+	defaultOutput        = "log:debug"
+	coprocessStdout      = "log:info"
+	coprocessStderr      = "log:warn"
+	defaultMaxOutputSize = 64 * 1024
+)
+
+// Output describes where a stream (stdout/stderr) of a job is routed to.
+type Output struct {
+	Kind  OutputKind
+	Level logrus.Level
+	// Path is the absolute path of the target file for OutputFile and OutputRotate.
+	Path string
+}
+
+// ParseOutput parses an output destination, one of:
+// `discard`, `log`, `log:<level>`, `file:<path>` (relative to the state dir)
+// or `rotate` (a rotating per-handler log in the state dir).
+func ParseOutput(destination string, handlerID string) (*Output, error) {
+	switch {
+	case destination == outputDiscard:
+		return &Output{Kind: OutputDiscard}, nil
+	case destination == outputLog:
+		return &Output{Kind: OutputLog, Level: logrus.DebugLevel}, nil
+	case strings.HasPrefix(destination, outputLog+":"):
+		level, err := logrus.ParseLevel(strings.TrimPrefix(destination, outputLog+":"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid log level: %w", err)
+		}
+		return &Output{Kind: OutputLog, Level: level}, nil
+	case strings.HasPrefix(destination, outputFilePrefix):
+		path, err := stateFilePath(strings.TrimPrefix(destination, outputFilePrefix))
+		if err != nil {
+			return nil, err
+		}
+		return &Output{Kind: OutputFile, Path: path}, nil
+	case destination == outputRotate:
+		path, err := stateFilePath(filepath.Join("handlers", handlerID+".log"))
+		if err != nil {
+			return nil, err
+		}
+		return &Output{Kind: OutputRotate, Path: path}, nil
+	}
+	return nil, fmt.Errorf("unknown output destination %q", destination)
+}
+
+func stateFilePath(relative string) (string, error) {
+	if relative == "" {
+		return "", errors.New("file path can't be empty")
+	}
+	if filepath.IsAbs(relative) || !filepath.IsLocal(relative) {
+		return "", fmt.Errorf("file path %q has to be relative and stay within the state directory", relative)
+	}
+	stateDir, err := utils.GetStateDir()
+	if err != nil {
+		return "", fmt.Errorf("cant get state dir: %w", err)
+	}
+	return filepath.Join(stateDir, relative), nil
+}
+
+func handlerID(index int) string {
+	return "handler-" + strconv.Itoa(index)
+}
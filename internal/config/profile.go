@@ -0,0 +1,57 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+)
+
+func (r *RawConfig) validateProfiles() error {
+	var found diagnostics
+	defaults := map[string]string{}
+	for _, name := range r.ProfileNames() {
+		profile := r.Profiles[name]
+		if !namePattern.MatchString(name) {
+			found.add(fmt.Errorf("profile name %q is invalid, it has to match %s", name, namePattern))
+			continue
+		}
+		if profile.Group == nil || !profile.IsDefault() {
+			continue
+		}
+		if previous, ok := defaults[*profile.Group]; ok {
+			found.add(fmt.Errorf("profiles %s and %s of group %s can't both be default", previous, name, *profile.Group))
+			continue
+		}
+		defaults[*profile.Group] = name
+	}
+	for _, event := range r.Events {
+		if event.Profile == nil {
+			continue
+		}
+		if _, ok := r.Profiles[*event.Profile]; !ok {
+			found.add(event.validationError(fmt.Errorf("profile %q is not defined", *event.Profile)))
+		}
+	}
+	return found.err()
+}
+
+func (p *Profile) IsDefault() bool {
+	return p.Default != nil && *p.Default
+}
+
+// ProfileNames returns the names of the defined profiles, sorted.
+func (r *RawConfig) ProfileNames() []string {
+	names := make([]string, 0, len(r.Profiles))
+	for name := range r.Profiles {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// DefaultProfiles returns the profiles that are active when no profile was
+// switched yet.
+func (r *RawConfig) DefaultProfiles() []string {
+	return slices.DeleteFunc(r.ProfileNames(), func(name string) bool {
+		return !r.Profiles[name].IsDefault()
+	})
+}
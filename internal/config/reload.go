@@ -0,0 +1,34 @@
+package config
+
+import (
+	"context"
+	"os"
+	"os/exec"
+
+	"github.com/sirupsen/logrus"
+)
+
+const ReloadErrorEnvVar = "HWT_RELOAD_ERROR"
+
+// runReloadErrorHook runs the on_reload_error command of the config that is
+// still in use, with the reload error exposed as $HWT_RELOAD_ERROR.
+func (c *Config) runReloadErrorHook(ctx context.Context, reloadErr error) {
+	general := c.Get().General
+	if general.OnReloadError == nil {
+		return
+	}
+	hookCtx, cancel := context.WithTimeout(ctx, *general.Timeout)
+	defer cancel()
+
+	// nolint:gosec
+	cmd := exec.CommandContext(hookCtx, "bash", "-c", *general.OnReloadError)
+	cmd.Env = append(os.Environ(), ReloadErrorEnvVar+"="+reloadErr.Error())
+	cmd.Dir = c.Get().Dir
+	out, err := cmd.CombinedOutput()
+	fields := logrus.Fields{"command": *general.OnReloadError, "output": string(out)}
+	if err != nil {
+		logrus.WithError(err).WithFields(fields).Warn("on_reload_error command failed")
+		return
+	}
+	logrus.WithFields(fields).Debug("on_reload_error command finished")
+}
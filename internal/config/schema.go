@@ -0,0 +1,187 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+const (
+	schemaDraft = "https://json-schema.org/draft/2020-12/schema"
+	schemaID    = "https://raw.githubusercontent.com/fiffeek/hyprwhenthen/main/schema.json"
+)
+
+// durationPattern matches the strings accepted by time.ParseDuration.
+const durationPattern = `^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`
+
+// Schema is the subset of JSON Schema used to describe the config.
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	ID                   string             `json:"$id,omitempty"`
+	Ref                  string             `json:"$ref,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Minimum              *int               `json:"minimum,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties any                `json:"additionalProperties,omitempty"`
+	Defs                 map[string]*Schema `json:"$defs,omitempty"`
+}
+
+// schemaDefs names the structs described once under $defs.
+var schemaDefs = map[reflect.Type]string{
+	reflect.TypeFor[GeneralSection](): "general",
+	reflect.TypeFor[Event]():          "handler",
+	reflect.TypeFor[Step]():           "step",
+	reflect.TypeFor[Profile]():        "profile",
+}
+
+// schemaEnums lists the allowed values of the string fields, keyed by the
+// struct name and the key of the field.
+var schemaEnums = map[string][]string{
+	"GeneralSection.session_env": {SessionEnvDaemon, SessionEnvHyprland, SessionEnvFile},
+	"Step.on_failure":            {OnFailureAbort, OnFailureContinue},
+	"Event.stdin":                {StdinNone, StdinJSON},
+	"Event.on_reload":            {OnReloadRun, OnReloadCancel},
+}
+
+// schemaMinimums lists the lower bounds of the integer fields.
+var schemaMinimums = map[string]int{
+	"GeneralSection.max_output_size": 1,
+	"GeneralSection.workers":         1,
+	"GeneralSection.queue_size":      0,
+	"Event.retries":                  0,
+	"Event.max_output_size":          1,
+}
+
+// schemaFormats lists the fields with a JSON Schema format.
+var schemaFormats = map[string]string{
+	"Event.when": "regex",
+}
+
+// schemaRequired lists the keys that have to be set.
+var schemaRequired = map[reflect.Type][]string{
+	reflect.TypeFor[Step](): {"run"},
+}
+
+// GenerateSchema describes the config file as a JSON Schema, generated from
+// the config structs so that the two can't drift apart.
+func GenerateSchema() (*Schema, error) {
+	defs := map[string]*Schema{}
+	for typ, name := range schemaDefs {
+		def, err := structSchema(typ)
+		if err != nil {
+			return nil, fmt.Errorf("cant generate the schema of %s: %w", typ.Name(), err)
+		}
+		defs[name] = def
+	}
+
+	root, err := structSchema(reflect.TypeFor[RawConfig]())
+	if err != nil {
+		return nil, fmt.Errorf("cant generate the schema of RawConfig: %w", err)
+	}
+	root.Schema = schemaDraft
+	root.ID = schemaID
+	root.Title = "hyprwhenthen config"
+	root.Defs = defs
+	// Editors read the schema location from the document itself.
+	root.Properties["$schema"] = &Schema{Type: "string", Description: "Location of the JSON schema"}
+	return root, nil
+}
+
+// MarshalSchema returns the indented JSON of the schema.
+func MarshalSchema() ([]byte, error) {
+	schema, err := GenerateSchema()
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(schema); err != nil {
+		return nil, fmt.Errorf("cant encode the schema: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func structSchema(typ reflect.Type) (*Schema, error) {
+	schema := &Schema{
+		Type:                 "object",
+		Properties:           map[string]*Schema{},
+		Required:             schemaRequired[typ],
+		AdditionalProperties: false,
+	}
+	for i := range typ.NumField() {
+		field := typ.Field(i)
+		key, ok := field.Tag.Lookup("toml")
+		if !ok || key == "-" {
+			continue
+		}
+		doc := field.Tag.Get("doc")
+		if doc == "" {
+			return nil, fmt.Errorf("field %s has no doc tag", field.Name)
+		}
+		property, err := typeSchema(field.Type)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", field.Name, err)
+		}
+		property.Description = doc
+
+		id := typ.Name() + "." + key
+		if enum, ok := schemaEnums[id]; ok {
+			property.Enum = enum
+		}
+		if minimum, ok := schemaMinimums[id]; ok {
+			property.Minimum = &minimum
+		}
+		property.Format = schemaFormats[id]
+		schema.Properties[key] = property
+	}
+	return schema, nil
+}
+
+func typeSchema(typ reflect.Type) (*Schema, error) {
+	if typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	if name, ok := schemaDefs[typ]; ok {
+		return &Schema{Ref: "#/$defs/" + name}, nil
+	}
+	if typ == reflect.TypeFor[time.Duration]() {
+		return &Schema{Type: "string", Pattern: durationPattern}, nil
+	}
+
+	switch typ.Kind() {
+	case reflect.String:
+		return &Schema{Type: "string"}, nil
+	case reflect.Int:
+		return &Schema{Type: "integer"}, nil
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}, nil
+	case reflect.Slice:
+		items, err := typeSchema(typ.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return &Schema{Type: "array", Items: items}, nil
+	case reflect.Map:
+		if typ.Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("map keys of type %s are not supported", typ.Key())
+		}
+		values, err := typeSchema(typ.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return &Schema{Type: "object", AdditionalProperties: values}, nil
+	default:
+		return nil, fmt.Errorf("type %s is not supported", typ)
+	}
+}
@@ -0,0 +1,268 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fiffeek/hyprwhenthen/internal/utils"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// syntaxCheckTimeout bounds a single `bash -n` run.
+const syntaxCheckTimeout = 5 * time.Second
+
+// eventContextVar is the variable holding the raw event data, like the
+// captures it is controlled by whoever sets the window title.
+const eventContextVar = "HWT_EVENT_CONTEXT"
+
+var groupVariable = regexp.MustCompile(`^` + regexGroupPrefix + `([0-9]+)$`)
+
+// wrappers run the command given as their first argument.
+var wrappers = []string{"exec", "command", "nohup", "setsid"}
+
+// bashBuiltins are the names that bash resolves without PATH, as listed by
+// `compgen -b` and `compgen -k`.
+var bashBuiltins = []string{
+	".", ":", "[", "[[", "]]", "{", "}", "!", "alias", "bg", "bind", "break", "builtin", "caller", "case", "cd",
+	"command", "compgen", "complete", "compopt", "continue", "declare", "dirs", "disown", "do", "done", "echo",
+	"elif", "else", "enable", "esac", "eval", "exec", "exit", "export", "false", "fc", "fg", "fi", "for",
+	"function", "getopts", "hash", "help", "history", "if", "in", "jobs", "kill", "let", "local", "logout",
+	"mapfile", "popd", "printf", "pushd", "pwd", "read", "readarray", "readonly", "return", "select", "set",
+	"shift", "shopt", "source", "suspend", "test", "then", "time", "times", "trap", "true", "type", "typeset",
+	"ulimit", "umask", "unalias", "unset", "until", "wait", "while",
+}
+
+// finding is a problem found in a shell command.
+type finding struct {
+	severity string
+	message  string
+}
+
+// shellCommand is a command of a handler along with the key it is set by.
+type shellCommand struct {
+	key  string
+	text string
+	// captures tells whether the command receives the capture groups.
+	captures bool
+}
+
+// Strict runs static checks of the shell commands: the syntax is checked with
+// `bash -n` and the programs have to be found on PATH or relative to the
+// working directory, these are errors. The capture groups that don't exist or
+// are not quoted are reported as warnings. The config has to be validated
+// first.
+func (r *RawConfig) Strict(ctx context.Context) []Diagnostic {
+	var found []Diagnostic
+	for _, event := range r.Events {
+		groups := regexp.MustCompile(event.When).NumSubexp()
+		path := os.Getenv("PATH")
+		// The handler env overrides the env file, the same as for the jobs.
+		for _, env := range []map[string]string{event.FileEnv, event.Env} {
+			if value, ok := env["PATH"]; ok {
+				path = value
+			}
+		}
+		for _, command := range event.commands() {
+			for _, finding := range checkCommand(ctx, command, utils.Deref(event.Workdir), path, groups) {
+				found = append(found, event.diagnostic(finding.severity,
+					fmt.Sprintf("event %s: %s %s", event.describe(), command.key, finding.message)))
+			}
+		}
+	}
+	if r.General.OnReloadError != nil {
+		hook := shellCommand{key: "on_reload_error", text: *r.General.OnReloadError}
+		for _, finding := range checkCommand(ctx, hook, r.Dir, os.Getenv("PATH"), 0) {
+			diagnostic := r.general
+			diagnostic.Severity = finding.severity
+			diagnostic.Message = fmt.Sprintf("general section: %s %s", hook.key, finding.message)
+			found = append(found, diagnostic)
+		}
+	}
+	return found
+}
+
+// commands returns the shell commands of the handler, the ones that are Go
+// templates are left out as they are only known once rendered.
+func (r *Event) commands() []shellCommand {
+	var commands []shellCommand
+	if !r.TemplateEnabled() {
+		if r.Then != "" {
+			commands = append(commands, shellCommand{key: "then", text: r.Then, captures: true})
+		}
+		for i, step := range r.Steps {
+			commands = append(commands, shellCommand{key: fmt.Sprintf("steps[%d]", i), text: step.Run, captures: true})
+		}
+	}
+	if r.Check != nil {
+		commands = append(commands, shellCommand{key: "check", text: *r.Check, captures: true})
+	}
+	if r.Coprocess != nil {
+		commands = append(commands, shellCommand{key: "coprocess", text: *r.Coprocess})
+	}
+	return commands
+}
+
+func checkCommand(ctx context.Context, command shellCommand, workdir, path string, groups int) []finding {
+	if err := bashSyntax(ctx, command.text); err != nil {
+		return []finding{{SeverityError, err.Error()}}
+	}
+	file, err := syntax.NewParser(syntax.Variant(syntax.LangBash)).Parse(strings.NewReader(command.text), command.key)
+	if err != nil {
+		return []finding{{SeverityError, fmt.Sprintf("can't be parsed: %v", err)}}
+	}
+
+	var findings []finding
+	warn := func(messages ...string) {
+		for _, message := range messages {
+			findings = append(findings, finding{SeverityWarning, message})
+		}
+	}
+	functions := map[string]bool{}
+	syntax.Walk(file, func(node syntax.Node) bool {
+		if decl, ok := node.(*syntax.FuncDecl); ok {
+			functions[decl.Name.Value] = true
+		}
+		return true
+	})
+	syntax.Walk(file, func(node syntax.Node) bool {
+		switch node := node.(type) {
+		case *syntax.CallExpr:
+			if program := programName(node.Args); program != "" && !functions[program] {
+				if err := lookupProgram(program, workdir, path); err != nil {
+					findings = append(findings, finding{SeverityError, err.Error()})
+				}
+			}
+			for _, word := range node.Args {
+				warn(unquotedCaptures(word)...)
+			}
+		case *syntax.Redirect:
+			if node.Word != nil {
+				warn(unquotedCaptures(node.Word)...)
+			}
+		case *syntax.ParamExp:
+			if message := groupMessage(node, command.captures, groups); message != "" {
+				warn(message)
+			}
+		}
+		return true
+	})
+	return slices.Compact(findings)
+}
+
+// bashSyntax checks the command with `bash -n`, which parses it without
+// running anything.
+func bashSyntax(ctx context.Context, text string) error {
+	ctx, cancel := context.WithTimeout(ctx, syntaxCheckTimeout)
+	defer cancel()
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "bash", "-n", "-c", text)
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		lines := strings.Split(strings.TrimSpace(stderr.String()), "\n")
+		for i, line := range lines {
+			lines[i] = strings.TrimPrefix(line, "bash: -c: ")
+		}
+		return fmt.Errorf("has a syntax error: %s", strings.Join(lines, "; "))
+	}
+	if err != nil {
+		return fmt.Errorf("cant run bash -n: %w", err)
+	}
+	return nil
+}
+
+// programName returns the program that a simple command runs, looking
+// through the wrappers; it is empty when not known statically.
+func programName(args []*syntax.Word) string {
+	wrapped := false
+	for _, arg := range args {
+		name := arg.Lit()
+		switch {
+		case slices.Contains(wrappers, name):
+			wrapped = true
+		case wrapped && strings.HasPrefix(name, "-"):
+		default:
+			return name
+		}
+	}
+	return ""
+}
+
+// lookupProgram resolves the program the way bash does, names with a slash
+// and relative PATH entries are relative to the working directory.
+func lookupProgram(program, workdir, path string) error {
+	if slices.Contains(bashBuiltins, program) {
+		return nil
+	}
+	if home, found := strings.CutPrefix(program, "~/"); found {
+		program = filepath.Join(os.Getenv("HOME"), home)
+	}
+	if strings.Contains(program, "/") {
+		if !filepath.IsAbs(program) {
+			program = filepath.Join(workdir, program)
+		}
+		if !isExecutable(program) {
+			return fmt.Errorf("runs %s which is not an executable file", program)
+		}
+		return nil
+	}
+	for _, dir := range filepath.SplitList(path) {
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(workdir, dir)
+		}
+		if isExecutable(filepath.Join(dir, program)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("runs %s which is not found on PATH", program)
+}
+
+func isExecutable(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && !fi.IsDir() && fi.Mode()&0o111 != 0
+}
+
+// groupMessage reports the capture group variables that the job won't have.
+func groupMessage(param *syntax.ParamExp, captures bool, groups int) string {
+	if param.Param == nil {
+		return ""
+	}
+	match := groupVariable.FindStringSubmatch(param.Param.Value)
+	if match == nil {
+		return ""
+	}
+	if !captures {
+		return fmt.Sprintf("uses $%s but capture groups are not passed to it", param.Param.Value)
+	}
+	if group, err := strconv.Atoi(match[1]); err == nil && group > groups {
+		return fmt.Sprintf("uses $%s but the regex has %d capture groups", param.Param.Value, groups)
+	}
+	return ""
+}
+
+// unquotedCaptures reports the captures and the event data that are expanded
+// outside of double quotes, so they are split into words and globbed.
+func unquotedCaptures(word *syntax.Word) []string {
+	var messages []string
+	for _, part := range word.Parts {
+		param, ok := part.(*syntax.ParamExp)
+		if !ok || param.Param == nil {
+			continue
+		}
+		if name := param.Param.Value; name == eventContextVar || groupVariable.MatchString(name) {
+			messages = append(messages, fmt.Sprintf("expands $%s without quotes, use \"$%s\"", name, name))
+		}
+	}
+	return messages
+}
@@ -0,0 +1,106 @@
+package eventprocessor
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/fiffeek/hyprwhenthen/internal/config"
+	"github.com/fiffeek/hyprwhenthen/internal/hypr"
+	"github.com/fiffeek/hyprwhenthen/internal/script"
+	"github.com/fiffeek/hyprwhenthen/internal/workerpool"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Outcome tells what a handler does with an event.
+type Outcome struct {
+	Handler *config.Event
+	// Captures are the regex groups, set once the regex matches.
+	Captures []string
+	// Job is the job that would be submitted, nil when the handler is skipped.
+	Job *workerpool.Job
+	// Reason tells why the handler is skipped.
+	Reason string
+}
+
+// Match goes through the handlers of the event type in order and creates the
+// jobs of the ones that react to the event, nothing is executed. base is the
+// session environment that the jobs are started with, state the store of the
+// scripts (nil when there is none).
+func Match(cfg *config.RawConfig, active func(*config.Event) bool, base []string, state *script.Store,
+	event *hypr.Event,
+) ([]*Outcome, error) {
+	onEvents, found := cfg.OnEvents[event.EventType]
+	if !found {
+		logrus.Debugf("System is not configured to react to %s event type", event.EventType)
+		return nil, nil
+	}
+
+	outcomes := make([]*Outcome, 0, len(onEvents))
+	for _, matcher := range onEvents {
+		outcome := &Outcome{Handler: matcher}
+		outcomes = append(outcomes, outcome)
+		if !active(matcher) {
+			logrus.WithFields(logrus.Fields{
+				"handler": matcher.Label(),
+				"profile": *matcher.Profile,
+			}).Debug("Profile of the handler is not active, skipping...")
+			outcome.Reason = fmt.Sprintf("profile %s is not active", *matcher.Profile)
+			continue
+		}
+
+		reg, err := regexp.Compile(matcher.When)
+		if err != nil {
+			return nil, fmt.Errorf("cant compile regexp %s: %w", matcher.When, err)
+		}
+
+		if !reg.Match(event.EventContextBytes) {
+			logrus.WithFields(logrus.Fields{
+				"event":   event.EventContext,
+				"handler": matcher.Label(),
+				"regex":   matcher.When,
+			}).Debug("Event does not match regex, skipping...")
+			outcome.Reason = "regex does not match"
+			continue
+		}
+
+		trigger := &workerpool.Trigger{
+			EventType:    event.EventType,
+			EventContext: event.EventContext,
+			EventTime:    event.Time,
+			Captures:     reg.FindStringSubmatch(event.EventContext),
+			ConfigDir:    cfg.Dir,
+			ConfigPath:   cfg.Files[0],
+			Generation:   cfg.Generation,
+		}
+		outcome.Captures = trigger.Captures
+		logrus.WithFields(logrus.Fields{"captures": trigger.Captures}).Debug("Captured regex groups for job")
+
+		if matcher.Condition != nil {
+			matched, err := matcher.Condition.Eval(conditionEnv(trigger, matcher, base, state))
+			if err != nil {
+				logrus.WithError(err).WithFields(logrus.Fields{"handler": matcher.Label()}).Warn("Cant evaluate condition, skipping")
+				outcome.Reason = fmt.Sprintf("cant evaluate condition: %v", err)
+				continue
+			}
+			if !matched {
+				logrus.WithFields(logrus.Fields{
+					"event":     event.EventContext,
+					"handler":   matcher.Label(),
+					"condition": matcher.Condition,
+				}).Debug("Event does not match condition, skipping...")
+				outcome.Reason = "condition is false"
+				continue
+			}
+		}
+
+		job, err := workerpool.NewJob(trigger, matcher, base)
+		if err != nil {
+			logrus.WithError(err).WithFields(logrus.Fields{"handler": matcher.Label()}).Error("Cant create a job, skipping")
+			outcome.Reason = fmt.Sprintf("cant create a job: %v", err)
+			continue
+		}
+		outcome.Job = job
+	}
+	return outcomes, nil
+}
@@ -6,12 +6,15 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"regexp"
-	"strconv"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/fiffeek/hyprwhenthen/internal/condition"
 	"github.com/fiffeek/hyprwhenthen/internal/config"
 	"github.com/fiffeek/hyprwhenthen/internal/hypr"
+	"github.com/fiffeek/hyprwhenthen/internal/profile"
+	"github.com/fiffeek/hyprwhenthen/internal/script"
 	"github.com/fiffeek/hyprwhenthen/internal/workerpool"
 
 	"github.com/sirupsen/logrus"
@@ -21,15 +24,17 @@ import (
 type Service struct {
 	ipc       *hypr.Service
 	pool      *workerpool.Service
+	profiles  *profile.Service
 	cfg       *config.Config
 	startOnce sync.Once
 }
 
-func NewService(ipc *hypr.Service, pool *workerpool.Service, cfg *config.Config) (*Service, error) {
+func NewService(ipc *hypr.Service, pool *workerpool.Service, profiles *profile.Service, cfg *config.Config) (*Service, error) {
 	return &Service{
-		ipc:  ipc,
-		pool: pool,
-		cfg:  cfg,
+		ipc:      ipc,
+		pool:     pool,
+		profiles: profiles,
+		cfg:      cfg,
 	}, nil
 }
 
@@ -55,7 +60,9 @@ func (s *Service) run(ctx context.Context) error {
 					return errors.New("results channel closed")
 				}
 				logrus.WithError(result.Err).WithFields(logrus.Fields{
-					"id": result.JobID, "exec": result.Exec,
+					"id": result.JobID, "exec": result.Exec, "attempts": result.Attempts,
+					"skipped": result.Skipped, "stale": result.Stale, "dry_run": result.DryRun,
+					"handler": result.Handler,
 				}).Info("Worker result collected")
 
 			case <-ctx.Done():
@@ -94,38 +101,17 @@ func (s *Service) run(ctx context.Context) error {
 }
 
 func (s *Service) process(ctx context.Context, event *hypr.Event) error {
-	cfg := s.cfg.Get()
-	onEvents, found := cfg.OnEvents[event.EventType]
-	if !found {
-		logrus.Debugf("System is not configured to react to %s event type", event.EventType)
-		return nil
+	outcomes, err := Match(s.cfg.Get(), s.profiles.Active, s.pool.Environ(), s.pool.State(), event)
+	if err != nil {
+		return err
 	}
-
-	for _, matcher := range onEvents {
-		reg, err := regexp.Compile(matcher.When)
-		if err != nil {
-			return fmt.Errorf("cant compile regexp %s: %w", matcher.When, err)
-		}
-
-		if !reg.Match(event.EventContextBytes) {
-			logrus.WithFields(logrus.Fields{
-				"event": event.EventContext,
-				"regex": matcher.When,
-			}).Debug("Event does not match regex, skipping...")
+	for _, outcome := range outcomes {
+		job := outcome.Job
+		if job == nil {
 			continue
 		}
-
-		env := map[string]string{}
-		matches := reg.FindStringSubmatch(event.EventContext)
-		for i, match := range matches {
-			key := "REGEX_GROUP_" + strconv.Itoa(i)
-			env[key] = match
-			logrus.WithFields(logrus.Fields{"key": key, "value": match}).Debug("Set env var for job")
-		}
-
-		job := workerpool.NewJob(env, matcher.Then, matcher.Timeout, matcher.RoutingKey)
 		logrus.WithFields(logrus.Fields{
-			"id": job.ID, "exec": job.Exec,
+			"id": job.ID, "exec": job.Exec, "handler": job.Name,
 			"routing_key": job.RoutingKey,
 		}).Info("Submitting execution to the pool")
 		if err := s.pool.Submit(ctx, job); err != nil {
@@ -135,3 +121,20 @@ func (s *Service) process(ctx context.Context, event *hypr.Event) error {
 	}
 	return nil
 }
+
+// conditionEnv exposes the environment the jobs of the handler get and the
+// state of the scripts to the condition.
+func conditionEnv(trigger *workerpool.Trigger, handler *config.Event, base []string, state *script.Store) *condition.Env {
+	env := map[string]string{}
+	for _, entry := range workerpool.HandlerEnviron(handler, base) {
+		key, value, _ := strings.Cut(entry, "=")
+		env[key] = value
+	}
+	return &condition.Env{
+		Event:    condition.NewEvent(trigger.EventType, trigger.EventContext, trigger.EventTime),
+		Captures: trigger.Captures,
+		Env:      env,
+		State:    state.Values(),
+		Now:      time.Now(),
+	}
+}
@@ -1,11 +1,14 @@
-// Package filewatcher provides a service that watches config files and issues
-// a debounced event with changes
+// Package filewatcher provides a service that watches config files (including
+// the included ones) and issues a debounced event with changes
 package filewatcher
 
 import (
 	"context"
 	"errors"
 	"fmt"
+	"path/filepath"
+	"slices"
+	"sync"
 
 	"github.com/fiffeek/hyprwhenthen/internal/config"
 	"github.com/fiffeek/hyprwhenthen/internal/utils"
@@ -23,6 +26,9 @@ type Service struct {
 	watcher   *fsnotify.Watcher
 	debouncer *utils.Debouncer
 	callback  Callback
+	mu        sync.Mutex
+	// paths are the watched files and the hops of their symlink chains.
+	paths []string
 }
 
 func NewService(cfg *config.Config, callback Callback) *Service {
@@ -46,7 +52,9 @@ func (s *Service) Run(ctx context.Context) error {
 	if err != nil {
 		return fmt.Errorf("cant create watcher: %w", err)
 	}
+	s.mu.Lock()
 	s.watcher = watcher
+	s.mu.Unlock()
 
 	eg.Go(func() error {
 		return s.debouncer.Run(ctx)
@@ -71,7 +79,7 @@ func (s *Service) Run(ctx context.Context) error {
 		return nil
 	})
 
-	if err := watcher.Add(s.cfg.Get().Dir); err != nil {
+	if err := s.watch(); err != nil {
 		return fmt.Errorf("cant watch config file changes: %w", err)
 	}
 
@@ -87,12 +95,14 @@ func (s *Service) runServiceLoop(ctx context.Context, watcher *fsnotify.Watcher)
 				return errors.New("watcher channel is closed")
 			}
 
-			logrus.WithFields(logrus.Fields{
-				"name":      event.Name,
-				"operation": event.Op,
-			}).Debug("Received filewatcher event")
+			fields := logrus.Fields{"name": event.Name, "operation": event.Op}
+			if !s.relevant(event.Name) {
+				logrus.WithFields(fields).Debug("Ignoring filewatcher event")
+				continue
+			}
+			logrus.WithFields(fields).Debug("Received filewatcher event")
 
-			s.debouncer.Do(ctx, *s.cfg.Get().General.HotReloadDebounceTimer, s.callback.OnEvent)
+			s.debouncer.Do(ctx, *s.cfg.Get().General.HotReloadDebounceTimer, s.reload)
 			logrus.WithFields(logrus.Fields{"fun": s.callback.OnEvent}).Debug("Scheduled debounced update")
 		case err, ok := <-watcher.Errors:
 			if !ok {
@@ -107,3 +117,69 @@ func (s *Service) runServiceLoop(ctx context.Context, watcher *fsnotify.Watcher)
 		}
 	}
 }
+
+// reload runs the callback and re-arms the watches, the reload (or a swapped
+// symlink) might have changed the files that make up the config. The watches
+// are updated even if the reload fails so that fixing the config is noticed.
+func (s *Service) reload(ctx context.Context) error {
+	err := s.callback.OnEvent(ctx)
+	if watchErr := s.watch(); watchErr != nil {
+		logrus.WithError(watchErr).Warn("Cant update watched directories")
+	}
+	return err
+}
+
+// relevant tells whether an event for path can change the config: path is a
+// config, env or script file, a hop of their symlink chains or a file that
+// matches an include pattern. The watched directories (/nix/store, a dotfiles
+// repository) can be busy with unrelated files.
+func (s *Service) relevant(path string) bool {
+	path = filepath.Clean(path)
+	s.mu.Lock()
+	watched := slices.Contains(s.paths, path)
+	s.mu.Unlock()
+	return watched || s.cfg.Get().MatchesInclude(path)
+}
+
+// watch syncs the watched directories with the ones of the current config,
+// including the directories along the symlink chains of the config files.
+func (s *Service) watch() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.watcher == nil {
+		return nil
+	}
+	cfg := s.cfg.Get()
+	dirs := cfg.WatchDirs()
+	paths := []string{}
+	for _, file := range cfg.WatchFiles() {
+		dirs = append(dirs, symlinkDirs(file)...)
+		paths = append(paths, symlinkChain(file)...)
+	}
+	slices.Sort(dirs)
+	dirs = slices.Compact(dirs)
+	s.paths = paths
+
+	// The watch list is used instead of tracking the added directories,
+	// watches of removed directories are dropped by fsnotify.
+	watched := s.watcher.WatchList()
+	for _, dir := range watched {
+		if slices.Contains(dirs, dir) {
+			continue
+		}
+		logrus.WithField("dir", dir).Debug("Removing watch")
+		if err := s.watcher.Remove(dir); err != nil {
+			logrus.WithError(err).WithField("dir", dir).Debug("Cant remove watch")
+		}
+	}
+	for _, dir := range dirs {
+		if slices.Contains(watched, dir) {
+			continue
+		}
+		logrus.WithField("dir", dir).Debug("Adding watch")
+		if err := s.watcher.Add(dir); err != nil {
+			return fmt.Errorf("cant watch %s: %w", dir, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package filewatcher
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// maxSymlinkHops mirrors the kernel limit of nested symlinks (MAXSYMLINKS).
+const maxSymlinkHops = 40
+
+// symlinkChain returns path, every hop of its symlink chain and the fully
+// resolved file. These are the paths whose events can change the contents
+// of path.
+func symlinkChain(path string) []string {
+	chain := []string{path}
+	current := path
+	for range maxSymlinkHops {
+		target, err := os.Readlink(current)
+		if err != nil {
+			break
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(current), target)
+		}
+		chain = append(chain, target)
+		current = target
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		chain = append(chain, resolved)
+	}
+	return chain
+}
+
+// symlinkDirs returns the directories of every hop of the symlink chain of
+// path and the directory of the fully resolved file. Editing a symlink
+// target (e.g. in a dotfiles repository) only emits events in the target
+// directory, while swapping a link emits events in the directory of the link.
+func symlinkDirs(path string) []string {
+	dirs := []string{}
+	for _, hop := range symlinkChain(path)[1:] {
+		if fi, err := os.Stat(filepath.Dir(hop)); err == nil && fi.IsDir() {
+			dirs = append(dirs, filepath.Dir(hop))
+		}
+	}
+	return dirs
+}
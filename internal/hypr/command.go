@@ -0,0 +1,53 @@
+package hypr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/fiffeek/hyprwhenthen/internal/dial"
+	"github.com/fiffeek/hyprwhenthen/internal/utils"
+)
+
+// SignatureEnvVar identifies the Hyprland instance of the session.
+const SignatureEnvVar = "HYPRLAND_INSTANCE_SIGNATURE"
+
+// Dispatch runs a dispatcher through the command socket of the Hyprland
+// instance with the given signature, the same as `hyprctl dispatch <args>`,
+// and returns the response.
+func Dispatch(ctx context.Context, signature, args string) (string, error) {
+	return Command(ctx, signature, "dispatch "+args)
+}
+
+// Command sends a raw request to the command socket of the Hyprland instance
+// with the given signature.
+func Command(ctx context.Context, signature, request string) (string, error) {
+	if signature == "" {
+		return "", errors.New("hyprland instance signature is not set")
+	}
+	xdgRuntimeDir, err := utils.GetXDGRuntimeDir()
+	if err != nil {
+		return "", fmt.Errorf("cant get xdg runtime dir: %w", err)
+	}
+
+	conn, teardown, err := dial.GetUnixSocketConnection(ctx, GetHyprCommandSocket(xdgRuntimeDir, signature))
+	if err != nil {
+		return "", fmt.Errorf("cant connect to hypr command socket: %w", err)
+	}
+	defer teardown()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return "", fmt.Errorf("cant set deadline: %w", err)
+		}
+	}
+
+	if _, err := conn.Write([]byte(request)); err != nil {
+		return "", fmt.Errorf("cant write request: %w", err)
+	}
+	response, err := io.ReadAll(conn)
+	if err != nil {
+		return "", fmt.Errorf("cant read response: %w", err)
+	}
+	return string(response), nil
+}
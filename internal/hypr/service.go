@@ -16,15 +16,21 @@ import (
 	"golang.org/x/sync/errgroup"
 )
 
+// ConnectCallback is notified when the connection to the Hyprland events socket is established.
+type ConnectCallback interface {
+	OnConnect(context.Context) error
+}
+
 type Service struct {
 	instanceSignature string
 	xdgRuntimeDir     string
 	events            chan *Event
 	cfg               *config.Config
+	onConnect         ConnectCallback
 }
 
-func NewService(ctx context.Context, cfg *config.Config) (*Service, error) {
-	signature := os.Getenv("HYPRLAND_INSTANCE_SIGNATURE")
+func NewService(ctx context.Context, cfg *config.Config, onConnect ConnectCallback) (*Service, error) {
+	signature := os.Getenv(SignatureEnvVar)
 	if signature == "" {
 		return nil, errors.New("HYPRLAND_INSTANCE_SIGNATURE environment variable not set - are you running under Hyprland?")
 	}
@@ -39,6 +45,7 @@ func NewService(ctx context.Context, cfg *config.Config) (*Service, error) {
 		xdgRuntimeDir:     xdgRuntimeDir,
 		events:            make(chan *Event, 100),
 		cfg:               cfg,
+		onConnect:         onConnect,
 	}, nil
 }
 
@@ -54,6 +61,9 @@ func (i *Service) Run(ctx context.Context) error {
 	if err != nil {
 		return fmt.Errorf("cant open unix events socket connection to %s: %w", socketPath, err)
 	}
+	if err := i.onConnect.OnConnect(ctx); err != nil {
+		logrus.WithError(err).Warn("Connect callback failed")
+	}
 
 	eg.Go(func() error {
 		<-ctx.Done()
@@ -77,7 +87,7 @@ func (i *Service) Run(ctx context.Context) error {
 
 			line := scanner.Text()
 			cfg := i.cfg.Get()
-			found, event := getRegisteredEvent(cfg, line)
+			found, event := RegisteredEvent(cfg, line)
 			if !found {
 				logrus.WithFields(logrus.Fields{"line": line}).Debug("Event not registered")
 				continue
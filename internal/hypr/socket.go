@@ -5,3 +5,7 @@ import "fmt"
 func GetHyprEventsSocket(xdgRuntimeDir, instanceSignature string) string {
 	return fmt.Sprintf("%s/hypr/%s/.socket2.sock", xdgRuntimeDir, instanceSignature)
 }
+
+func GetHyprCommandSocket(xdgRuntimeDir, instanceSignature string) string {
+	return fmt.Sprintf("%s/hypr/%s/.socket.sock", xdgRuntimeDir, instanceSignature)
+}
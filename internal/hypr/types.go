@@ -2,6 +2,7 @@ package hypr
 
 import (
 	"strings"
+	"time"
 
 	"github.com/fiffeek/hyprwhenthen/internal/config"
 )
@@ -10,9 +11,12 @@ type Event struct {
 	EventType         string
 	EventContext      string
 	EventContextBytes []byte
+	Time              time.Time
 }
 
-func getRegisteredEvent(cfg *config.RawConfig, line string) (bool, *Event) {
+// RegisteredEvent parses an event line of the IPC socket, events that no handler
+// reacts to are not returned.
+func RegisteredEvent(cfg *config.RawConfig, line string) (bool, *Event) {
 	for _, key := range cfg.EventKeys {
 		after, done := strings.CutPrefix(line, key+">>")
 		if done {
@@ -20,6 +24,7 @@ func getRegisteredEvent(cfg *config.RawConfig, line string) (bool, *Event) {
 				EventType:         key,
 				EventContext:      after,
 				EventContextBytes: []byte(after),
+				Time:              time.Now(),
 			}
 		}
 	}
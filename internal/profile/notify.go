@@ -0,0 +1,90 @@
+package profile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/fiffeek/hyprwhenthen/internal/config"
+	"github.com/fiffeek/hyprwhenthen/internal/utils"
+)
+
+const pidFileName = "hyprwhenthen-%s.pid"
+
+// ErrNotRunning is returned when there is no service to notify.
+var ErrNotRunning = errors.New("service is not running")
+
+func pidPath(cfg *config.RawConfig) (string, error) {
+	runtimeDir, err := utils.GetXDGRuntimeDir()
+	if err != nil {
+		return "", fmt.Errorf("cant get runtime dir: %w", err)
+	}
+	return filepath.Join(runtimeDir, fmt.Sprintf(pidFileName, instanceKey(cfg))), nil
+}
+
+// removePidFile removes the pid file unless another instance of the service
+// has taken it over in the meantime.
+func removePidFile(path string) {
+	pid, err := readPid(path)
+	if err != nil || pid != os.Getpid() {
+		return
+	}
+	_ = os.Remove(path)
+}
+
+func readPid(path string) (int, error) {
+	// nolint:gosec
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("cant read pid file: %w", err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(contents)))
+	if err != nil {
+		return 0, fmt.Errorf("pid file %s is invalid: %w", path, err)
+	}
+	return pid, nil
+}
+
+// Notify asks the service running with the config to re-read the profiles state.
+func Notify(cfg *config.RawConfig) error {
+	path, err := pidPath(cfg)
+	if err != nil {
+		return err
+	}
+	pid, err := readPid(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNotRunning
+	}
+	if err != nil {
+		return err
+	}
+	// A stale pid file might point at an unrelated process by now.
+	if !sameExecutable(pid) {
+		return ErrNotRunning
+	}
+	if err := syscall.Kill(pid, ReloadSignal); err != nil {
+		if errors.Is(err, syscall.ESRCH) {
+			return ErrNotRunning
+		}
+		return fmt.Errorf("cant signal the service: %w", err)
+	}
+	return nil
+}
+
+func sameExecutable(pid int) bool {
+	self, err := os.Executable()
+	if err != nil {
+		return false
+	}
+	target, err := os.Readlink(filepath.Join("/proc", strconv.Itoa(pid), "exe"))
+	if err != nil {
+		return false
+	}
+	// The binary might have been upgraded while the service is running.
+	target = strings.TrimSuffix(target, " (deleted)")
+	return filepath.Base(target) == filepath.Base(self)
+}
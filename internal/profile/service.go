@@ -0,0 +1,88 @@
+package profile
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/fiffeek/hyprwhenthen/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+// ReloadSignal makes the service re-read the profiles state.
+const ReloadSignal = syscall.SIGUSR1
+
+// Service holds the active profiles of the running service.
+type Service struct {
+	cfg   *config.Config
+	mu    sync.RWMutex
+	state *State
+	sigCh chan os.Signal
+}
+
+func NewService(cfg *config.Config) (*Service, error) {
+	s := &Service{cfg: cfg, sigCh: make(chan os.Signal, 1)}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Service) load() error {
+	state, err := ReadState(s.cfg.Get())
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.state = state
+	s.mu.Unlock()
+	logrus.WithField("active", strings.Join(state.Active, ",")).Info("Active profiles")
+	return nil
+}
+
+// Active tells whether the handler should react to events, handlers outside
+// of profiles are always active.
+func (s *Service) Active(handler *config.Event) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.state.HandlerActive(handler)
+}
+
+// Run publishes the pid of the service for the CLI and re-reads the state
+// whenever ReloadSignal is received. The signal is caught before the pid is
+// published, its default action would terminate the service.
+func (s *Service) Run(ctx context.Context) error {
+	signal.Notify(s.sigCh, ReloadSignal)
+	defer signal.Stop(s.sigCh)
+
+	path, err := pidPath(s.cfg.Get())
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0o600); err != nil {
+		return fmt.Errorf("cant write pid file: %w", err)
+	}
+	defer removePidFile(path)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		case <-s.sigCh:
+			logrus.Debug("Reloading the profiles state")
+			if err := s.load(); err != nil {
+				logrus.WithError(err).Error("Cant reload the profiles state, keeping the previous one")
+			}
+		}
+	}
+}
+
+// OnConfigReload re-reads the state, the default profiles might have changed.
+func (s *Service) OnConfigReload(context.Context) error {
+	return s.load()
+}
@@ -0,0 +1,117 @@
+// Package profile keeps track of the active profiles, the state is persisted
+// so that it survives restarts and is shared with the CLI.
+package profile
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/fiffeek/hyprwhenthen/internal/config"
+	"github.com/fiffeek/hyprwhenthen/internal/utils"
+)
+
+const stateFileName = "profiles-%s.json"
+
+// State lists the active profiles, a missing state means that the default
+// profiles are active.
+type State struct {
+	Active []string `json:"active"`
+}
+
+// instanceKey identifies the service by its absolute config path, so that
+// services started with different configs keep separate state and pid files.
+func instanceKey(cfg *config.RawConfig) string {
+	sum := sha256.Sum256([]byte(cfg.Files[0]))
+	return hex.EncodeToString(sum[:6])
+}
+
+func statePath(cfg *config.RawConfig) (string, error) {
+	stateDir, err := utils.GetStateDir()
+	if err != nil {
+		return "", fmt.Errorf("cant get state dir: %w", err)
+	}
+	return filepath.Join(stateDir, fmt.Sprintf(stateFileName, instanceKey(cfg))), nil
+}
+
+// ReadState returns the persisted state or the default profiles of the config
+// when nothing was persisted yet.
+func ReadState(cfg *config.RawConfig) (*State, error) {
+	path, err := statePath(cfg)
+	if err != nil {
+		return nil, err
+	}
+	// nolint:gosec
+	contents, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &State{Active: cfg.DefaultProfiles()}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cant read profiles state: %w", err)
+	}
+	var state State
+	if err := json.Unmarshal(contents, &state); err != nil {
+		return nil, fmt.Errorf("cant decode profiles state %s: %w", path, err)
+	}
+	return &state, nil
+}
+
+func WriteState(cfg *config.RawConfig, state *State) error {
+	path, err := statePath(cfg)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return fmt.Errorf("cant create state dir: %w", err)
+	}
+	contents, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("cant encode profiles state: %w", err)
+	}
+	return utils.WriteAtomic(path, contents)
+}
+
+func (s *State) IsActive(name string) bool {
+	return slices.Contains(s.Active, name)
+}
+
+// HandlerActive tells whether the handler reacts to events, handlers outside
+// of profiles are always active.
+func (s *State) HandlerActive(handler *config.Event) bool {
+	return handler.Profile == nil || s.IsActive(*handler.Profile)
+}
+
+// Enable activates the profile and deactivates the other profiles of its group.
+func (s *State) Enable(cfg *config.RawConfig, name string) error {
+	profile, ok := cfg.Profiles[name]
+	if !ok {
+		return fmt.Errorf("profile %q is not defined", name)
+	}
+	s.Active = slices.DeleteFunc(s.Active, func(active string) bool {
+		other, ok := cfg.Profiles[active]
+		return active == name || (ok && profile.Group != nil && other.Group != nil && *other.Group == *profile.Group)
+	})
+	s.Active = append(s.Active, name)
+	slices.Sort(s.Active)
+	return nil
+}
+
+func (s *State) Disable(cfg *config.RawConfig, name string) error {
+	if _, ok := cfg.Profiles[name]; !ok {
+		return fmt.Errorf("profile %q is not defined", name)
+	}
+	s.Active = slices.DeleteFunc(s.Active, func(active string) bool { return active == name })
+	return nil
+}
+
+func (s *State) Toggle(cfg *config.RawConfig, name string) error {
+	if s.IsActive(name) {
+		return s.Disable(cfg, name)
+	}
+	return s.Enable(cfg, name)
+}
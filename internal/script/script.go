@@ -0,0 +1,188 @@
+// Package script provides sandboxed Starlark handlers. Scripts run in-process,
+// they can't access the filesystem or the network directly, only through the
+// provided built-ins.
+package script
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
+)
+
+// maxExecutionSteps guards against runaway loops in addition to the timeout.
+const maxExecutionSteps = 100_000_000
+
+var fileOptions = &syntax.FileOptions{
+	Set:             true,
+	While:           true,
+	TopLevelControl: true,
+	GlobalReassign:  true,
+}
+
+var predeclaredNames = map[string]bool{
+	"event":    true,
+	"captures": true,
+	"env":      true,
+	"state":    true,
+	"run":      true,
+	"dispatch": true,
+	"log":      true,
+}
+
+// Program is a compiled script.
+type Program struct {
+	name    string
+	program *starlark.Program
+}
+
+// Compile parses and resolves a script, undefined names and syntax errors are
+// reported here.
+func Compile(name, source string) (*Program, error) {
+	_, program, err := starlark.SourceProgramOptions(fileOptions, name, source, func(name string) bool {
+		return predeclaredNames[name]
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cant compile script: %w", err)
+	}
+	if program.NumLoads() > 0 {
+		return nil, errors.New("load statements are not supported")
+	}
+	return &Program{name: name, program: program}, nil
+}
+
+func (p *Program) Name() string {
+	return p.name
+}
+
+// Input is the data that a script can access.
+type Input struct {
+	EventType    string
+	EventContext string
+	EventTime    time.Time
+	Captures     []string
+	Env          map[string]string
+	Handler      string
+}
+
+// RunResult is the outcome of the `run` built-in.
+type RunResult struct {
+	Code   int
+	Stdout string
+	Stderr string
+}
+
+// Runtime provides the side effects available to scripts, dispatches go to
+// the Hyprland instance with the given Signature.
+type Runtime struct {
+	State     *Store
+	Run       func(ctx context.Context, command string) (*RunResult, error)
+	Dispatch  func(ctx context.Context, signature, args string) (string, error)
+	Signature string
+}
+
+// Exec runs the script until completion, cancellation of ctx interrupts it.
+func (p *Program) Exec(ctx context.Context, input *Input, runtime *Runtime) error {
+	fields := logrus.Fields{"handler": input.Handler, "script": p.name}
+	thread := &starlark.Thread{
+		Name: p.name,
+		Print: func(_ *starlark.Thread, msg string) {
+			logrus.WithFields(fields).Info(msg)
+		},
+	}
+	thread.SetMaxExecutionSteps(maxExecutionSteps)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			thread.Cancel(context.Cause(ctx).Error())
+		case <-done:
+		}
+	}()
+
+	if _, err := p.program.Init(thread, predeclared(ctx, input, runtime, fields)); err != nil {
+		var evalErr *starlark.EvalError
+		if errors.As(err, &evalErr) {
+			return fmt.Errorf("script failed: %s", evalErr.Backtrace())
+		}
+		return fmt.Errorf("script failed: %w", err)
+	}
+	return nil
+}
+
+func predeclared(ctx context.Context, input *Input, runtime *Runtime, fields logrus.Fields) starlark.StringDict {
+	captures := make(starlark.Tuple, 0, len(input.Captures))
+	for _, capture := range input.Captures {
+		captures = append(captures, starlark.String(capture))
+	}
+	env := starlark.NewDict(len(input.Env))
+	for key, value := range input.Env {
+		_ = env.SetKey(starlark.String(key), starlark.String(value))
+	}
+	env.Freeze()
+
+	return starlark.StringDict{
+		"event": starlarkstruct.FromStringDict(starlark.String("event"), starlark.StringDict{
+			"type":    starlark.String(input.EventType),
+			"context": starlark.String(input.EventContext),
+			"time":    starlark.String(input.EventTime.Format(time.RFC3339Nano)),
+		}),
+		"captures": captures,
+		"env":      env,
+		"state":    runtime.State.module(),
+		"run": starlark.NewBuiltin("run", func(_ *starlark.Thread, b *starlark.Builtin,
+			args starlark.Tuple, kwargs []starlark.Tuple,
+		) (starlark.Value, error) {
+			var command string
+			if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &command); err != nil {
+				return nil, err
+			}
+			result, err := runtime.Run(ctx, command)
+			if err != nil {
+				return nil, err
+			}
+			return starlarkstruct.FromStringDict(starlark.String("result"), starlark.StringDict{
+				"code":   starlark.MakeInt(result.Code),
+				"stdout": starlark.String(result.Stdout),
+				"stderr": starlark.String(result.Stderr),
+			}), nil
+		}),
+		"dispatch": starlark.NewBuiltin("dispatch", func(_ *starlark.Thread, b *starlark.Builtin,
+			args starlark.Tuple, kwargs []starlark.Tuple,
+		) (starlark.Value, error) {
+			var dispatcherArgs string
+			if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &dispatcherArgs); err != nil {
+				return nil, err
+			}
+			response, err := runtime.Dispatch(ctx, runtime.Signature, dispatcherArgs)
+			if err != nil {
+				return nil, err
+			}
+			return starlark.String(response), nil
+		}),
+		"log": starlark.NewBuiltin("log", func(_ *starlark.Thread, b *starlark.Builtin,
+			args starlark.Tuple, kwargs []starlark.Tuple,
+		) (starlark.Value, error) {
+			var msg, level string
+			if err := starlark.UnpackArgs(b.Name(), args, kwargs, "msg", &msg, "level?", &level); err != nil {
+				return nil, err
+			}
+			parsed := logrus.InfoLevel
+			if level != "" {
+				var err error
+				if parsed, err = logrus.ParseLevel(level); err != nil {
+					return nil, fmt.Errorf("invalid log level: %w", err)
+				}
+			}
+			logrus.WithFields(fields).Log(parsed, msg)
+			return starlark.None, nil
+		}),
+	}
+}
@@ -0,0 +1,142 @@
+package script
+
+import (
+	"sort"
+	"sync"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// Store is an in-memory key-value store shared by all scripts, it lives as
+// long as the service. Values are frozen when stored, so they can be safely
+// shared between concurrently running scripts.
+type Store struct {
+	mu     sync.Mutex
+	values map[string]starlark.Value
+}
+
+func NewStore() *Store {
+	return &Store{values: map[string]starlark.Value{}}
+}
+
+func (s *Store) module() *starlarkstruct.Module {
+	return &starlarkstruct.Module{
+		Name: "state",
+		Members: starlark.StringDict{
+			"get":    starlark.NewBuiltin("state.get", s.get),
+			"set":    starlark.NewBuiltin("state.set", s.set),
+			"delete": starlark.NewBuiltin("state.delete", s.delete),
+			"keys":   starlark.NewBuiltin("state.keys", s.keys),
+		},
+	}
+}
+
+func (s *Store) get(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var key string
+	var fallback starlark.Value = starlark.None
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "key", &key, "default?", &fallback); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if value, ok := s.values[key]; ok {
+		return value, nil
+	}
+	return fallback, nil
+}
+
+func (s *Store) set(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var key string
+	var value starlark.Value
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "key", &key, "value", &value); err != nil {
+		return nil, err
+	}
+	value.Freeze()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+	return starlark.None, nil
+}
+
+func (s *Store) delete(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var key string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "key", &key); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.values, key)
+	return starlark.None, nil
+}
+
+func (s *Store) keys(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	keys := make([]string, 0, len(s.values))
+	for key := range s.values {
+		keys = append(keys, key)
+	}
+	s.mu.Unlock()
+	sort.Strings(keys)
+	values := make([]starlark.Value, 0, len(keys))
+	for _, key := range keys {
+		values = append(values, starlark.String(key))
+	}
+	return starlark.NewList(values), nil
+}
+
+// Values converts the stored values to Go values, e.g. for `if` expressions.
+// Values that have no Go counterpart are converted to their string form.
+func (s *Store) Values() map[string]any {
+	values := map[string]any{}
+	if s == nil {
+		return values
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, value := range s.values {
+		values[key] = toGo(value)
+	}
+	return values
+}
+
+func toGo(value starlark.Value) any {
+	switch v := value.(type) {
+	case starlark.NoneType:
+		return nil
+	case starlark.Bool:
+		return bool(v)
+	case starlark.Int:
+		if i, ok := v.Int64(); ok {
+			return int(i)
+		}
+		return v.String()
+	case starlark.Float:
+		return float64(v)
+	case starlark.String:
+		return string(v)
+	case starlark.Bytes:
+		return string(v)
+	case starlark.Indexable:
+		items := make([]any, 0, v.Len())
+		for i := range v.Len() {
+			items = append(items, toGo(v.Index(i)))
+		}
+		return items
+	case *starlark.Dict:
+		items := map[string]any{}
+		for _, item := range v.Items() {
+			key, ok := starlark.AsString(item[0])
+			if !ok {
+				key = item[0].String()
+			}
+			items[key] = toGo(item[1])
+		}
+		return items
+	default:
+		return v.String()
+	}
+}
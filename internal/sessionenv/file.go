@@ -0,0 +1,60 @@
+package sessionenv
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/fiffeek/hyprwhenthen/internal/utils"
+)
+
+// fileSource reads the variables from an env file, e.g. written by Hyprland's
+// `exec-once = env > $XDG_RUNTIME_DIR/hyprwhenthen.env`. The file is re-read
+// whenever it changes.
+type fileSource struct {
+	path    string
+	mu      sync.Mutex
+	modTime time.Time
+	vars    map[string]string
+}
+
+func newFileSource(path string) *fileSource {
+	return &fileSource{path: path}
+}
+
+func (f *fileSource) Vars() (map[string]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fi, err := os.Stat(f.path)
+	if err != nil {
+		return nil, fmt.Errorf("cant stat session env file: %w", err)
+	}
+	if f.vars != nil && fi.ModTime().Equal(f.modTime) {
+		return f.vars, nil
+	}
+	if err := f.load(fi.ModTime()); err != nil {
+		return nil, err
+	}
+	return f.vars, nil
+}
+
+func (f *fileSource) Refresh() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fi, err := os.Stat(f.path)
+	if err != nil {
+		return fmt.Errorf("cant stat session env file: %w", err)
+	}
+	return f.load(fi.ModTime())
+}
+
+func (f *fileSource) load(modTime time.Time) error {
+	vars, err := utils.ParseEnvFile(f.path)
+	if err != nil {
+		return fmt.Errorf("cant parse session env file: %w", err)
+	}
+	f.vars = vars
+	f.modTime = modTime
+	return nil
+}
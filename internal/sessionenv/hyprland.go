@@ -0,0 +1,223 @@
+package sessionenv
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fiffeek/hyprwhenthen/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	signatureEnvVar = "HYPRLAND_INSTANCE_SIGNATURE"
+	waylandEnvVar   = "WAYLAND_DISPLAY"
+	lockFile        = "hyprland.lock"
+)
+
+// hyprlandSource snapshots the environment of the running Hyprland instance.
+// Hyprland sets some variables (instance signature, wayland display) after
+// start, these are not visible in /proc/<pid>/environ and are recovered from
+// the instance lock file instead. The snapshot is taken again whenever the
+// newest instance changes, e.g. after Hyprland restarts.
+type hyprlandSource struct {
+	mu        sync.Mutex
+	pid       int
+	signature string
+	vars      map[string]string
+	// dirModTime is the modification time of the instances directory when the
+	// instance was resolved, a new instance adds its directory to it.
+	dirModTime time.Time
+}
+
+func newHyprlandSource() *hyprlandSource {
+	return &hyprlandSource{}
+}
+
+// Vars resolves the newest instance only when the cached one might be stale,
+// so that jobs don't scan all the instances.
+func (h *hyprlandSource) Vars() (map[string]string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.vars != nil && h.fresh() {
+		return h.vars, nil
+	}
+	instance, err := h.resolve()
+	if err != nil {
+		return nil, err
+	}
+	if h.vars != nil && instance.pid == h.pid && instance.signature == h.signature {
+		return h.vars, nil
+	}
+	logrus.Debug("Hyprland session env is stale, refreshing")
+	if err := h.load(instance); err != nil {
+		return nil, err
+	}
+	return h.vars, nil
+}
+
+func (h *hyprlandSource) Refresh() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	instance, err := h.resolve()
+	if err != nil {
+		return err
+	}
+	return h.load(instance)
+}
+
+// fresh tells whether the cached instance is still the newest one: it is
+// alive and no instance was started since.
+func (h *hyprlandSource) fresh() bool {
+	modTime, err := instancesModTime()
+	return err == nil && modTime.Equal(h.dirModTime) && processAlive(h.pid)
+}
+
+// resolve finds the newest instance, the instances directory is checked first
+// so that an instance started in the meantime is not missed.
+func (h *hyprlandSource) resolve() (*instance, error) {
+	modTime, err := instancesModTime()
+	if err != nil {
+		return nil, err
+	}
+	instance, err := findInstance()
+	if err != nil {
+		return nil, err
+	}
+	h.dirModTime = modTime
+	return instance, nil
+}
+
+func (h *hyprlandSource) load(instance *instance) error {
+	vars, err := readProcessEnviron(instance.pid)
+	if err != nil {
+		return err
+	}
+	vars[signatureEnvVar] = instance.signature
+	if instance.waylandDisplay != "" {
+		vars[waylandEnvVar] = instance.waylandDisplay
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"pid": instance.pid, "signature": instance.signature,
+	}).Debug("Loaded Hyprland session env")
+	h.pid = instance.pid
+	h.signature = instance.signature
+	h.vars = vars
+	return nil
+}
+
+type instance struct {
+	pid            int
+	signature      string
+	waylandDisplay string
+	modTime        time.Time
+}
+
+func instancesDir() (string, error) {
+	xdgRuntimeDir, err := utils.GetXDGRuntimeDir()
+	if err != nil {
+		return "", fmt.Errorf("cant get xdg runtime dir: %w", err)
+	}
+	return filepath.Join(xdgRuntimeDir, "hypr"), nil
+}
+
+func instancesModTime() (time.Time, error) {
+	dir, err := instancesDir()
+	if err != nil {
+		return time.Time{}, err
+	}
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("cant stat hyprland instances: %w", err)
+	}
+	return fi.ModTime(), nil
+}
+
+// findInstance returns the most recently started live Hyprland instance.
+func findInstance() (*instance, error) {
+	dir, err := instancesDir()
+	if err != nil {
+		return nil, err
+	}
+	locks, err := filepath.Glob(filepath.Join(dir, "*", lockFile))
+	if err != nil {
+		return nil, fmt.Errorf("cant list hyprland instances: %w", err)
+	}
+
+	var newest *instance
+	for _, lock := range locks {
+		candidate, err := readLock(lock)
+		if err != nil {
+			logrus.WithError(err).WithFields(logrus.Fields{"lock": lock}).Debug("Skipping Hyprland instance")
+			continue
+		}
+		if !processAlive(candidate.pid) {
+			continue
+		}
+		if newest == nil || candidate.modTime.After(newest.modTime) {
+			newest = candidate
+		}
+	}
+	if newest == nil {
+		return nil, errors.New("no running Hyprland instance found")
+	}
+	return newest, nil
+}
+
+// readLock parses the instance lock file, it holds the pid and the wayland socket name.
+func readLock(path string) (*instance, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("cant stat lock: %w", err)
+	}
+	// nolint:gosec
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cant read lock: %w", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(contents)), "\n")
+	pid, err := strconv.Atoi(strings.TrimSpace(lines[0]))
+	if err != nil {
+		return nil, fmt.Errorf("lock does not start with a pid: %w", err)
+	}
+	result := &instance{
+		pid:       pid,
+		signature: filepath.Base(filepath.Dir(path)),
+		modTime:   fi.ModTime(),
+	}
+	if len(lines) > 1 {
+		result.waylandDisplay = strings.TrimSpace(lines[1])
+	}
+	return result, nil
+}
+
+func readProcessEnviron(pid int) (map[string]string, error) {
+	// nolint:gosec
+	contents, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "environ"))
+	if err != nil {
+		return nil, fmt.Errorf("cant read environment of %d: %w", pid, err)
+	}
+	vars := map[string]string{}
+	for _, entry := range bytes.Split(contents, []byte{0}) {
+		key, value, found := strings.Cut(string(entry), "=")
+		if found && key != "" {
+			vars[key] = value
+		}
+	}
+	return vars, nil
+}
+
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	_, err := os.Stat(filepath.Join("/proc", strconv.Itoa(pid)))
+	return err == nil
+}
@@ -0,0 +1,113 @@
+// Package sessionenv provides the environment of the current Hyprland session
+// for jobs, so that they do not inherit variables frozen at service start.
+package sessionenv
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/fiffeek/hyprwhenthen/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+// Source provides session variables that are overlaid on the service environment.
+type Source interface {
+	// Vars returns the current session variables, refreshing them when stale.
+	Vars() (map[string]string, error)
+	// Refresh forces a new snapshot of the session variables.
+	Refresh() error
+}
+
+type Service struct {
+	cfg    *config.Config
+	mu     sync.Mutex
+	source Source
+	key    string
+}
+
+func NewService(cfg *config.Config) *Service {
+	return &Service{cfg: cfg}
+}
+
+// Environ returns the environment that jobs should be started with.
+func (s *Service) Environ() []string {
+	source := s.current()
+	vars, err := source.Vars()
+	if err != nil {
+		logrus.WithError(err).Warn("Cant get session environment, falling back to the service environment")
+		return os.Environ()
+	}
+	return overlay(os.Environ(), vars)
+}
+
+// OnConnect refreshes the session variables once the service connected to
+// Hyprland at startup. The service does not reconnect, sources notice a new
+// session on their own.
+func (s *Service) OnConnect(context.Context) error {
+	return s.refresh()
+}
+
+// OnConfigReload refreshes the session variables when the config changes, the source
+// itself might have been changed.
+func (s *Service) OnConfigReload(context.Context) error {
+	return s.refresh()
+}
+
+func (s *Service) refresh() error {
+	if err := s.current().Refresh(); err != nil {
+		return fmt.Errorf("cant refresh session env: %w", err)
+	}
+	logrus.Debug("Session environment refreshed")
+	return nil
+}
+
+// current returns the source configured in the current config, recreating it when the
+// config changed.
+func (s *Service) current() Source {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	general := s.cfg.Get().General
+	key := *general.SessionEnv
+	if general.SessionEnvFile != nil {
+		key += ":" + *general.SessionEnvFile
+	}
+	if s.source != nil && s.key == key {
+		return s.source
+	}
+
+	logrus.WithFields(logrus.Fields{"source": key}).Debug("Creating session env source")
+	s.key = key
+	switch *general.SessionEnv {
+	case config.SessionEnvHyprland:
+		s.source = newHyprlandSource()
+	case config.SessionEnvFile:
+		s.source = newFileSource(*general.SessionEnvFile)
+	default:
+		s.source = daemonSource{}
+	}
+	return s.source
+}
+
+func overlay(base []string, vars map[string]string) []string {
+	env := make([]string, 0, len(base)+len(vars))
+	for _, entry := range base {
+		key, _, _ := strings.Cut(entry, "=")
+		if _, found := vars[key]; !found {
+			env = append(env, entry)
+		}
+	}
+	for key, value := range vars {
+		env = append(env, key+"="+value)
+	}
+	return env
+}
+
+// daemonSource keeps the environment the service was started with.
+type daemonSource struct{}
+
+func (daemonSource) Vars() (map[string]string, error) { return map[string]string{}, nil }
+
+func (daemonSource) Refresh() error { return nil }
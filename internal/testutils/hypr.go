@@ -2,6 +2,7 @@ package testutils
 
 import (
 	"context"
+	"fmt"
 	"net"
 	"os"
 	"path/filepath"
@@ -32,6 +33,12 @@ func SetupHyprEnvVars(t *testing.T) (string, string) {
 	return tempDir, signature
 }
 
+func SetupHyprLock(t *testing.T, xdgRuntimeDir, signature string, pid int, waylandDisplay string) {
+	lock := filepath.Join(xdgRuntimeDir, "hypr", signature, "hyprland.lock")
+	contents := fmt.Sprintf("%d\n%s\n", pid, waylandDisplay)
+	require.NoError(t, os.WriteFile(lock, []byte(contents), 0o600), "failed to create a lock file %s", lock)
+}
+
 func SetupHyprSocket(ctx context.Context, t *testing.T, xdgRuntimeDir, signature string,
 	hyprSocketFun func(string, string) string,
 ) (net.Listener, func()) {
@@ -51,6 +58,8 @@ func SetupFakeHyprEventsServer(ctx context.Context, t *testing.T, listener net.L
 			t.Errorf("Failed to accept connection: %v", err)
 			return
 		}
+		// Keeps the connection reachable, otherwise it is closed when garbage collected.
+		defer func() { _ = conn.Close() }()
 
 		t.Log("Accepted connection on events socket")
 
@@ -67,3 +76,57 @@ func SetupFakeHyprEventsServer(ctx context.Context, t *testing.T, listener net.L
 	}()
 	return serverDone
 }
+
+// SetupLiveHyprEventsServer writes the events sent on the channel, so that
+// the test can react to the state of the binary before sending more.
+func SetupLiveHyprEventsServer(ctx context.Context, t *testing.T, listener net.Listener, events <-chan string) chan struct{} {
+	serverDone := make(chan struct{})
+	go func() {
+		defer close(serverDone)
+		conn, err := listener.Accept()
+		if err != nil {
+			t.Errorf("Failed to accept connection: %v", err)
+			return
+		}
+		// Keeps the connection reachable, otherwise it is closed when garbage collected.
+		defer func() { _ = conn.Close() }()
+
+		t.Log("Accepted connection on events socket")
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event := <-events:
+				if _, err := conn.Write([]byte(event + "\n")); err != nil {
+					t.Errorf("Failed to write event: %v", err)
+					return
+				}
+				t.Log("Wrote event on the event socket")
+			}
+		}
+	}()
+	return serverDone
+}
+
+// SetupFakeHyprCommandServer answers every request on the command socket with
+// "ok: <request>" until the listener is closed.
+func SetupFakeHyprCommandServer(t *testing.T, listener net.Listener) {
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			request := make([]byte, 1024)
+			n, err := conn.Read(request)
+			if err != nil {
+				t.Logf("Failed to read command: %v", err)
+			}
+			if _, err := conn.Write([]byte("ok: " + string(request[:n]))); err != nil {
+				t.Logf("Failed to write response: %v", err)
+			}
+			_ = conn.Close()
+		}
+	}()
+}
@@ -1,6 +1,8 @@
 package testutils
 
 import (
+	"bytes"
+	"sync"
 	"testing"
 	"time"
 )
@@ -8,3 +10,22 @@ import (
 func Logf(t *testing.T, format string, args ...any) {
 	t.Logf("[%s]: "+format, append([]any{time.Now().Format(time.RFC3339Nano)}, args...)...)
 }
+
+// SyncBuffer collects the output of a running binary so that it can be read
+// before the binary exits.
+type SyncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *SyncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *SyncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
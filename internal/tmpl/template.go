@@ -0,0 +1,99 @@
+// Package tmpl provides text/template rendering of handler fields with a set
+// of helper functions for shell commands.
+package tmpl
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+type Event struct {
+	Type    string
+	Context string
+	Time    time.Time
+}
+
+// Data is the model the templates are rendered with, e.g. `{{ .Event.Type }}`,
+// `{{ index .Captures 1 }}` or `{{ .Env.HOME }}`.
+type Data struct {
+	Event       Event
+	Captures    []string
+	Env         map[string]string
+	Handler     string
+	HandlerName string
+	JobID       string
+}
+
+// Parse compiles a template with the helper functions available.
+func Parse(name, text string) (*template.Template, error) {
+	t, err := template.New(name).Option("missingkey=zero").Funcs(Funcs()).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("cant parse template: %w", err)
+	}
+	return t, nil
+}
+
+// Render executes a parsed template.
+func Render(t *template.Template, data *Data) (string, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("cant render template %s: %w", t.Name(), err)
+	}
+	return buf.String(), nil
+}
+
+// Funcs returns the helper functions, the value being transformed is always
+// the last argument so that the helpers can be used in pipelines:
+// `{{ index .Captures 1 | trimPrefix "0x" | shellquote }}`.
+func Funcs() template.FuncMap {
+	return template.FuncMap{
+		"shellquote":   ShellQuote,
+		"lower":        strings.ToLower,
+		"upper":        strings.ToUpper,
+		"trim":         strings.TrimSpace,
+		"trimPrefix":   func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+		"trimSuffix":   func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+		"replace":      func(old, replacement, s string) string { return strings.ReplaceAll(s, old, replacement) },
+		"contains":     func(substr, s string) bool { return strings.Contains(s, substr) },
+		"split":        func(sep, s string) []string { return strings.Split(s, sep) },
+		"default":      defaultValue,
+		"json":         toJSON,
+		"regexReplace": regexReplace,
+	}
+}
+
+// ShellQuote quotes s so that bash treats it as a single literal word.
+func ShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func defaultValue(fallback, value any) any {
+	if value == nil {
+		return fallback
+	}
+	if s, ok := value.(string); ok && s == "" {
+		return fallback
+	}
+	return value
+}
+
+func toJSON(value any) (string, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", fmt.Errorf("cant marshal to json: %w", err)
+	}
+	return string(data), nil
+}
+
+func regexReplace(pattern, replacement, s string) (string, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", fmt.Errorf("invalid regex %s: %w", pattern, err)
+	}
+	return re.ReplaceAllString(s, replacement), nil
+}
@@ -29,3 +29,26 @@ func GetXDGRuntimeDir() (string, error) {
 
 	return xdgRuntimeDir, nil
 }
+
+const XDGStateHome = "XDG_STATE_HOME"
+
+func GetXDGStateHome() (string, error) {
+	if xdgStateHome := os.Getenv(XDGStateHome); xdgStateHome != "" {
+		return xdgStateHome, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("cant get home dir: %w", err)
+	}
+	return filepath.Join(home, ".local", "state"), nil
+}
+
+// GetStateDir returns the directory where the service keeps its state and outputs.
+func GetStateDir() (string, error) {
+	stateHome, err := GetXDGStateHome()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(stateHome, "hyprwhenthen"), nil
+}
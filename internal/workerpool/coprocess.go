@@ -0,0 +1,392 @@
+package workerpool
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/fiffeek/hyprwhenthen/internal/config"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	coprocessMinBackoff = 100 * time.Millisecond
+	coprocessMaxBackoff = 30 * time.Second
+	// coprocessStableAfter resets the restart backoff when the coprocess ran for long enough.
+	coprocessStableAfter = 10 * time.Second
+	// coprocessPipeSize is the default capacity of a pipe on Linux.
+	coprocessPipeSize = 64 * 1024
+	// coprocessMaxLine is the longest line of output that is routed.
+	coprocessMaxLine = 1024 * 1024
+)
+
+// coprocessManager keeps long-lived handler processes that are fed with events
+// over stdin, one JSON line per event.
+type coprocessManager struct {
+	mu      sync.Mutex
+	procs   map[string]*coprocess
+	outputs *outputWriter
+}
+
+func newCoprocessManager(outputs *outputWriter) *coprocessManager {
+	return &coprocessManager{procs: map[string]*coprocess{}, outputs: outputs}
+}
+
+// send writes the job payload to the coprocess of the job's handler, starting
+// the coprocess first if needed. ctx bounds the coprocess lifetime, timeout
+// how long the job waits for the coprocess to accept the event.
+func (m *coprocessManager) send(ctx context.Context, job *Job, env []string, attempt int, timeout time.Duration) error {
+	payload, err := job.payloadJSON(attempt)
+	if err != nil {
+		return fmt.Errorf("cant prepare coprocess payload: %w", err)
+	}
+	proc := m.get(ctx, job, env)
+	sendCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if err := proc.send(sendCtx, append(payload, '\n')); err != nil {
+		return fmt.Errorf("cant send job %s to coprocess: %w", job.ID, err)
+	}
+	return nil
+}
+
+func (m *coprocessManager) get(parent context.Context, job *Job, env []string) *coprocess {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := job.Handler + "\x00" + job.Coprocess
+	if proc, ok := m.procs[key]; ok {
+		return proc
+	}
+	proc := newCoprocess(job, env, m.outputs)
+	proc.start(parent)
+	m.procs[key] = proc
+	return proc
+}
+
+// stopAll terminates all coprocesses, they are started again on the next event.
+func (m *coprocessManager) stopAll() {
+	m.mu.Lock()
+	procs := m.procs
+	m.procs = map[string]*coprocess{}
+	m.mu.Unlock()
+	for _, proc := range procs {
+		proc.stop()
+	}
+}
+
+type coprocess struct {
+	handler string
+	command string
+	workdir string
+	env     []string
+	stdout  *config.Output
+	stderr  *config.Output
+	outputs *outputWriter
+
+	mu    sync.Mutex
+	stdin *os.File
+	// kill terminates the current run.
+	kill context.CancelFunc
+	// started is closed once the current run accepts events, exited once it is over.
+	started chan struct{}
+	exited  chan struct{}
+	// pending are the lines that the previous run did not read, they are sent
+	// to the next one first.
+	pending [][]byte
+
+	// writeMu serializes the writes, written are the lines written to the
+	// current run that might still be in the pipe.
+	writeMu sync.Mutex
+	written [][]byte
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func newCoprocess(job *Job, env []string, outputs *outputWriter) *coprocess {
+	return &coprocess{
+		handler: job.Name,
+		command: job.Coprocess,
+		workdir: job.Workdir,
+		env:     env,
+		stdout:  job.Stdout,
+		stderr:  job.Stderr,
+		outputs: outputs,
+		started: make(chan struct{}),
+		exited:  make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+func (p *coprocess) fields() logrus.Fields {
+	return logrus.Fields{"handler": p.handler, "coprocess": p.command}
+}
+
+func (p *coprocess) start(parent context.Context) {
+	ctx, cancel := context.WithCancel(parent)
+	p.cancel = cancel
+	go p.supervise(ctx)
+}
+
+func (p *coprocess) stop() {
+	p.cancel()
+	<-p.done
+}
+
+// supervise keeps the coprocess running, restarting it with an exponential
+// backoff whenever it exits.
+func (p *coprocess) supervise(ctx context.Context) {
+	defer close(p.done)
+	backoff := coprocessMinBackoff
+	for {
+		startedAt := time.Now()
+		err := p.run(ctx)
+		if ctx.Err() != nil {
+			logrus.WithFields(p.fields()).Debug("Coprocess stopped")
+			return
+		}
+		if time.Since(startedAt) > coprocessStableAfter {
+			backoff = coprocessMinBackoff
+		}
+		logrus.WithError(err).WithFields(p.fields()).WithField("backoff", backoff).Warn("Coprocess exited, restarting")
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, coprocessMaxBackoff)
+	}
+}
+
+func (p *coprocess) run(ctx context.Context) error {
+	ctx, kill := context.WithCancel(ctx)
+	defer kill()
+	// nolint:gosec
+	cmd := exec.CommandContext(ctx, "bash", "-c", "exec "+p.command)
+	cmd.Env = p.env
+	cmd.Dir = p.workdir
+	// The pipe is created here to keep the write end as a file, see unread.
+	stdinReader, stdin, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("cant open stdin: %w", err)
+	}
+	defer func() { _ = stdin.Close() }()
+	cmd.Stdin = stdinReader
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		_ = stdinReader.Close()
+		return fmt.Errorf("cant open stdout: %w", err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		_ = stdinReader.Close()
+		return fmt.Errorf("cant open stderr: %w", err)
+	}
+	err = cmd.Start()
+	// Only the coprocess reads, writes fail once it exits.
+	_ = stdinReader.Close()
+	if err != nil {
+		return fmt.Errorf("cant start coprocess: %w", err)
+	}
+	logrus.WithFields(p.fields()).WithField("pid", cmd.Process.Pid).Info("Coprocess started")
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		p.readLines(stdout, "stdout", p.stdout)
+	}()
+	go func() {
+		defer wg.Done()
+		p.readLines(stderr, "stderr", p.stderr)
+	}()
+
+	p.resend(ctx, stdin)
+	p.mu.Lock()
+	p.stdin = stdin
+	p.kill = kill
+	exited := p.exited
+	close(p.started)
+	p.mu.Unlock()
+
+	wg.Wait()
+	err = cmd.Wait()
+
+	p.mu.Lock()
+	p.stdin = nil
+	p.kill = nil
+	p.started = make(chan struct{})
+	p.exited = make(chan struct{})
+	p.mu.Unlock()
+	// Writes in flight fail now that nobody reads the pipe, what is left in it
+	// was accepted but never read.
+	p.writeMu.Lock()
+	unread := p.unread(stdin)
+	p.written = nil
+	p.writeMu.Unlock()
+	p.mu.Lock()
+	p.pending = append(unread, p.pending...)
+	close(exited)
+	p.mu.Unlock()
+
+	if err != nil {
+		return fmt.Errorf("coprocess failed: %w", err)
+	}
+	return errors.New("coprocess exited")
+}
+
+// resend writes the lines that the previous run did not read, the ones that
+// can't be written are kept for the next run.
+func (p *coprocess) resend(ctx context.Context, stdin *os.File) {
+	p.mu.Lock()
+	pending := p.pending
+	p.pending = nil
+	p.mu.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+	logrus.WithFields(p.fields()).WithField("lines", len(pending)).Info("Resending the events the coprocess did not read")
+	for i, line := range pending {
+		if err := p.write(ctx, stdin, line); err != nil {
+			if errors.Is(err, errPartialWrite) {
+				// Tracked, it is among the unread lines of this run.
+				i++
+			}
+			p.mu.Lock()
+			p.pending = append(pending[i:], p.pending...)
+			p.mu.Unlock()
+			return
+		}
+	}
+}
+
+// unread returns the written lines that are still in the pipe, a line that was
+// partially read is returned whole. Has to be called with writeMu held.
+func (p *coprocess) unread(stdin *os.File) [][]byte {
+	conn, err := stdin.SyscallConn()
+	if err != nil {
+		return nil
+	}
+	var size int
+	var ioctlErr error
+	err = conn.Control(func(fd uintptr) {
+		// TIOCINQ is FIONREAD, for pipes it is the number of unread bytes.
+		size, ioctlErr = unix.IoctlGetInt(int(fd), unix.TIOCINQ)
+	})
+	if err != nil || ioctlErr != nil || size == 0 {
+		return nil
+	}
+	first := len(p.written)
+	for total := 0; first > 0 && total < size; {
+		first--
+		total += len(p.written[first])
+	}
+	return slices.Clone(p.written[first:])
+}
+
+// readLines routes the lines printed by the coprocess according to the
+// stdout/stderr settings of the handler. The output after a line that is too
+// long is discarded, the pipe is drained so that the coprocess doesn't block.
+func (p *coprocess) readLines(r io.Reader, stream string, output *config.Output) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), coprocessMaxLine)
+	for scanner.Scan() {
+		p.outputs.line(p.fields(), stream, output, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		logrus.WithError(err).WithFields(p.fields()).WithField("stream", stream).
+			Warn("Cant read coprocess output, discarding the rest of it")
+		_, _ = io.Copy(io.Discard, r)
+	}
+}
+
+// send writes a line to the coprocess, waiting for it to (re)start if needed.
+// A write that fails because the coprocess exited in the meantime is retried
+// once it is restarted, one that timed out is not: nothing was written.
+func (p *coprocess) send(ctx context.Context, line []byte) error {
+	for {
+		p.mu.Lock()
+		stdin, kill, started, exited := p.stdin, p.kill, p.started, p.exited
+		p.mu.Unlock()
+		if stdin != nil {
+			err := p.write(ctx, stdin, line)
+			if errors.Is(err, errPartialWrite) {
+				// The rest of the line would be glued to the next one, the
+				// restarted coprocess gets the whole line if it is unread.
+				logrus.WithFields(p.fields()).Warn("Coprocess accepted a part of the event, restarting it")
+				kill()
+				return nil
+			}
+			if err == nil || ctx.Err() != nil || errors.Is(err, os.ErrDeadlineExceeded) {
+				return err
+			}
+			logrus.WithError(err).WithFields(p.fields()).Debug("Coprocess did not accept the event, resending after a restart")
+			select {
+			case <-ctx.Done():
+				return err
+			case <-p.done:
+				return errors.New("coprocess is stopped")
+			case <-exited:
+			}
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		case <-p.done:
+			return errors.New("coprocess is stopped")
+		case <-started:
+		}
+	}
+}
+
+// errPartialWrite is returned when the write timed out after a part of the
+// line was written.
+var errPartialWrite = errors.New("coprocess accepted a part of the line")
+
+// write is bound by the deadline of ctx so that a coprocess that does not read
+// its stdin can't block the worker past the job timeout. A write that times out
+// leaves nothing in the pipe unless it returns errPartialWrite, so it can't be
+// delivered later.
+func (p *coprocess) write(ctx context.Context, stdin *os.File, line []byte) error {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	// The zero deadline (no deadline in ctx) blocks until the coprocess exits.
+	deadline, _ := ctx.Deadline()
+	if err := stdin.SetWriteDeadline(deadline); err != nil {
+		return fmt.Errorf("cant set write deadline: %w", err)
+	}
+	n, err := stdin.Write(line)
+	if n > 0 {
+		p.track(line)
+	}
+	switch {
+	case err == nil:
+		return nil
+	case n > 0:
+		return errPartialWrite
+	default:
+		return fmt.Errorf("cant write to coprocess: %w", err)
+	}
+}
+
+// track remembers the written line until it is surely read, i.e. until the
+// lines written after it exceed the pipe capacity.
+func (p *coprocess) track(line []byte) {
+	p.written = append(p.written, line)
+	total := 0
+	for i := len(p.written) - 1; i >= 0; i-- {
+		total += len(p.written[i])
+		if total > coprocessPipeSize {
+			p.written = p.written[i:]
+			return
+		}
+	}
+}
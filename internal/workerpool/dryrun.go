@@ -0,0 +1,68 @@
+package workerpool
+
+import (
+	"slices"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// dryRun tells whether jobs should be logged instead of executed, the
+// override takes precedence over the config until general.dry_run changes.
+func (s *Service) dryRun() bool {
+	if override := s.dryRunOverride.Load(); override != nil {
+		return *override
+	}
+	return *s.cfg.Get().General.DryRun
+}
+
+// followConfigDryRun drops the override once a reload changes general.dry_run,
+// so that the flag only sets the initial mode and the config can switch it.
+func (s *Service) followConfigDryRun() {
+	dryRun := *s.cfg.Get().General.DryRun
+	if s.configDryRun.Swap(dryRun) != dryRun {
+		s.dryRunOverride.Store(nil)
+	}
+}
+
+// announceDryRun logs the dry run mode when it is switched.
+func (s *Service) announceDryRun() {
+	dryRun := s.dryRun()
+	if s.dryRunMode.Swap(dryRun) == dryRun {
+		return
+	}
+	if dryRun {
+		logrus.Warn("Dry run enabled, jobs are logged instead of executed")
+		return
+	}
+	logrus.Info("Dry run disabled, jobs are executed")
+}
+
+// logDryRun logs what the attempt would execute. The variables inherited from
+// the session environment are left out, they are the same for all the jobs.
+func (s *Service) logDryRun(job *Job, attempt int, timeout time.Duration) {
+	env := job.environ(nil, attempt)
+	if job.Coprocess != "" {
+		env = job.coprocessEnviron(nil)
+	}
+	slices.Sort(env)
+	fields := logrus.Fields{
+		"id": job.ID, "handler": job.Name, "exec": job.Exec, "routing_key": job.RoutingKey,
+		"env": env, "timeout": timeout, "delay": time.Since(job.Trigger.EventTime),
+		"attempt": attempt,
+	}
+	if job.Check != "" {
+		fields["check"] = job.Check
+	}
+	if len(job.Steps) > 0 {
+		steps := make([]string, 0, len(job.Steps))
+		for _, step := range job.Steps {
+			steps = append(steps, step.Run)
+		}
+		fields["steps"] = steps
+	}
+	if job.Workdir != "" {
+		fields["workdir"] = job.Workdir
+	}
+	logrus.WithFields(fields).Info("Dry run, not executing the job")
+}
@@ -0,0 +1,150 @@
+package workerpool
+
+import (
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fiffeek/hyprwhenthen/internal/config"
+)
+
+const (
+	AttemptEnvVar      = "HWT_ATTEMPT"
+	EventTypeEnvVar    = "HWT_EVENT_TYPE"
+	EventContextEnvVar = "HWT_EVENT_CONTEXT"
+	EventTimeEnvVar    = "HWT_EVENT_TIME"
+	JobIDEnvVar        = "HWT_JOB_ID"
+	HandlerEnvVar      = "HWT_HANDLER"
+	HandlerNameEnvVar  = "HWT_HANDLER_NAME" // alias of HandlerEnvVar
+	RoutingKeyEnvVar   = "HWT_ROUTING_KEY"
+	ConfigDirEnvVar    = "HWT_CONFIG_DIR"
+	ConfigEnvVar       = "HWT_CONFIG"
+	RegexGroupPrefix   = "REGEX_GROUP_"
+)
+
+// eventEnv returns the variables describing the trigger of the job, the
+// routing key is not known yet at this point and is added later.
+func eventEnv(trigger *Trigger, jobID, handlerName string) map[string]string {
+	env := map[string]string{
+		EventTypeEnvVar:    trigger.EventType,
+		EventContextEnvVar: trigger.EventContext,
+		EventTimeEnvVar:    trigger.EventTime.Format(time.RFC3339Nano),
+		JobIDEnvVar:        jobID,
+		HandlerEnvVar:      handlerName,
+		HandlerNameEnvVar:  handlerName,
+		ConfigDirEnvVar:    trigger.ConfigDir,
+		ConfigEnvVar:       trigger.ConfigPath,
+	}
+	for i, capture := range trigger.Captures {
+		env[RegexGroupPrefix+strconv.Itoa(i)] = capture
+	}
+	return env
+}
+
+// environ builds the environment of a job execution, later entries take
+// precedence: inherited (or allowlisted) session env, handler env, event
+// variables and the attempt number.
+func (j *Job) environ(base []string, attempt int) []string {
+	return append(j.eventEnviron(base), AttemptEnvVar+"="+strconv.Itoa(attempt))
+}
+
+// eventEnviron is the environment of the job without the attempt number.
+func (j *Job) eventEnviron(base []string) []string {
+	env := j.handlerEnviron(base)
+	for key, value := range j.extraEnv {
+		env = append(env, key+"="+value)
+	}
+	return env
+}
+
+// Environ returns the environment that the first attempt of the job is started
+// with, for coprocesses it is the environment of the coprocess itself.
+func (j *Job) Environ(base []string) []string {
+	if j.Coprocess != "" {
+		return j.coprocessEnviron(base)
+	}
+	return j.environ(base, 1)
+}
+
+// coprocessEnviron builds the environment of a coprocess, it outlives single
+// events so only the handler level variables are set.
+func (j *Job) coprocessEnviron(base []string) []string {
+	env := j.handlerEnviron(base)
+	return append(env,
+		HandlerEnvVar+"="+j.Name,
+		HandlerNameEnvVar+"="+j.Name,
+		ConfigDirEnvVar+"="+j.Trigger.ConfigDir,
+		ConfigEnvVar+"="+j.Trigger.ConfigPath,
+	)
+}
+
+func (j *Job) handlerEnviron(base []string) []string {
+	env := inherited(base, j.InheritEnv, j.EnvAllowlist)
+	for key, value := range j.Env {
+		env = append(env, key+"="+value)
+	}
+	return env
+}
+
+// HandlerEnviron returns the handler level environment of the jobs of the
+// handler, i.e. without the event variables.
+func HandlerEnviron(handler *config.Event, base []string) []string {
+	env := inherited(base, handler.InheritsEnv(), handler.EnvAllowlist)
+	for key, value := range handler.FileEnv {
+		env = append(env, key+"="+value)
+	}
+	for key, value := range handler.Env {
+		env = append(env, key+"="+value)
+	}
+	return env
+}
+
+// inherited returns the entries of the session environment that jobs inherit.
+func inherited(base []string, inheritEnv bool, allowlist []string) []string {
+	env := []string{}
+	for _, entry := range base {
+		key, _, _ := strings.Cut(entry, "=")
+		if inheritEnv || allowed(key, allowlist) {
+			env = append(env, entry)
+		}
+	}
+	return env
+}
+
+// lookupEnv resolves the variable the way the job sees it, the session
+// environment is only consulted for the variables that the job inherits.
+func (j *Job) lookupEnv(base []string) func(string) string {
+	return func(key string) string {
+		if value, ok := j.extraEnv[key]; ok {
+			return value
+		}
+		if value, ok := j.Env[key]; ok {
+			return value
+		}
+		if !j.InheritEnv && !allowed(key, j.EnvAllowlist) {
+			return ""
+		}
+		return getenv(base, key)
+	}
+}
+
+// getenv returns the last value of the variable in env, the one that wins.
+func getenv(env []string, key string) string {
+	for _, entry := range slices.Backward(env) {
+		if name, value, _ := strings.Cut(entry, "="); name == key {
+			return value
+		}
+	}
+	return ""
+}
+
+func allowed(key string, allowlist []string) bool {
+	for _, pattern := range allowlist {
+		if matched, _ := path.Match(pattern, key); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package workerpool
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const CheckOutputEnvVar = "HWT_CHECK_OUTPUT"
+
+type guardResult struct {
+	passed  bool
+	output  string
+	expires time.Time
+}
+
+// guardCache keeps check results per handler and routing key.
+type guardCache struct {
+	mu      sync.Mutex
+	results map[string]*guardResult
+}
+
+func newGuardCache() *guardCache {
+	return &guardCache{results: map[string]*guardResult{}}
+}
+
+// guardKey keys the results by handler and routing key, jobs of handlers
+// without a routing key (which default it to the job ID) are keyed by the
+// handler alone so they share one result.
+func guardKey(job *Job) string {
+	if job.RoutingKey == job.ID.String() {
+		return job.Handler
+	}
+	return job.Handler + "\x00" + job.RoutingKey
+}
+
+func (c *guardCache) get(key string, now time.Time) (*guardResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	result, ok := c.results[key]
+	if !ok || now.After(result.expires) {
+		return nil, false
+	}
+	return result, true
+}
+
+func (c *guardCache) set(key string, result *guardResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for existing, cached := range c.results {
+		if time.Now().After(cached.expires) {
+			delete(c.results, existing)
+		}
+	}
+	c.results[key] = result
+}
+
+func (c *guardCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.results = map[string]*guardResult{}
+}
+
+// guard runs the check command of the job (if any) and reports whether the job
+// should run, the check output is exposed to the job as $HWT_CHECK_OUTPUT.
+// A check that can't be executed or times out is treated as failed. Checks are
+// not run in dry run mode, the job logs them instead.
+func (s *Service) guard(ctx context.Context, job *Job) (bool, error) {
+	if job.Check == "" || job.dryRun {
+		return true, nil
+	}
+	fields := logrus.Fields{"id": job.ID, "handler": job.Name, "check": job.Check}
+	key := guardKey(job)
+	result, cached := s.guards.get(key, time.Now())
+	if cached {
+		logrus.WithFields(fields).WithField("passed", result.passed).Debug("Using cached check result")
+	} else {
+		result = s.runCheck(ctx, job, fields)
+		if ctx.Err() != nil {
+			return false, context.Cause(ctx)
+		}
+		if job.CheckCacheTTL > 0 {
+			result.expires = time.Now().Add(job.CheckCacheTTL)
+			s.guards.set(key, result)
+		}
+	}
+	if result.passed {
+		job.extraEnv[CheckOutputEnvVar] = result.output
+	}
+	return result.passed, nil
+}
+
+func (s *Service) runCheck(ctx context.Context, job *Job, fields logrus.Fields) *guardResult {
+	maxOutputSize := job.MaxOutputSize
+	if maxOutputSize == nil {
+		maxOutputSize = s.cfg.Get().General.MaxOutputSize
+	}
+	checkCtx, cancel := context.WithTimeout(ctx, job.CheckTimeout)
+	defer cancel()
+
+	result, err := runCommand(checkCtx, job.Check, job.environ(s.env.Environ(), 1), job.Workdir, *maxOutputSize)
+	if err != nil {
+		logrus.WithError(err).WithFields(fields).Warn("Check failed to run, skipping the job")
+		return &guardResult{}
+	}
+	if result.Code != 0 {
+		logrus.WithFields(fields).WithFields(logrus.Fields{
+			"code": result.Code, "stderr": result.Stderr,
+		}).Debug("Check did not pass")
+		return &guardResult{}
+	}
+	return &guardResult{passed: true, output: strings.TrimRight(result.Stdout, "\n")}
+}
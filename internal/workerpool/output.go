@@ -0,0 +1,163 @@
+package workerpool
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fiffeek/hyprwhenthen/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	rotateMaxSize = 1024 * 1024
+	rotateBackups = 3
+)
+
+// cappedBuffer keeps at most limit bytes and silently drops the rest, so
+// a chatty command can't take up unbounded memory.
+type cappedBuffer struct {
+	buf     bytes.Buffer
+	limit   int
+	dropped int
+}
+
+func newCappedBuffer(limit int) *cappedBuffer {
+	return &cappedBuffer{limit: limit}
+}
+
+func (c *cappedBuffer) Write(p []byte) (int, error) {
+	remaining := max(c.limit-c.buf.Len(), 0)
+	kept := min(len(p), remaining)
+	c.buf.Write(p[:kept])
+	c.dropped += len(p) - kept
+	return len(p), nil
+}
+
+func (c *cappedBuffer) Bytes() []byte {
+	return c.buf.Bytes()
+}
+
+// outputWriter routes captured job outputs to their destinations.
+type outputWriter struct {
+	mu sync.Mutex
+}
+
+// capture returns a writer for the stream or nil when the stream is discarded.
+func (o *outputWriter) capture(output *config.Output, limit int) *cappedBuffer {
+	if output == nil || output.Kind == config.OutputDiscard {
+		return nil
+	}
+	return newCappedBuffer(limit)
+}
+
+func (o *outputWriter) flush(job *Job, stream string, output *config.Output, captured *cappedBuffer) {
+	if captured == nil {
+		return
+	}
+	fields := logrus.Fields{"id": job.ID, "handler": job.Name, "exec": job.Exec, "stream": stream}
+	if captured.dropped > 0 {
+		logrus.WithFields(fields).WithField("dropped", captured.dropped).Warn("Job output exceeded max_output_size, truncated")
+	}
+
+	switch output.Kind {
+	case config.OutputDiscard:
+	case config.OutputLog:
+		logrus.WithFields(fields).Logf(output.Level, "Command output %s", captured.Bytes())
+	case config.OutputFile:
+		if err := o.appendTo(output.Path, captured.Bytes()); err != nil {
+			logrus.WithError(err).WithFields(fields).Error("Cant write job output to a file")
+		}
+	case config.OutputRotate:
+		header := fmt.Sprintf("--- %s job=%s stream=%s dropped=%s\n",
+			time.Now().Format(time.RFC3339Nano), job.ID, stream, strconv.Itoa(captured.dropped))
+		if err := o.appendRotating(output.Path, append([]byte(header), captured.Bytes()...)); err != nil {
+			logrus.WithError(err).WithFields(fields).Error("Cant write job output to a rotating log")
+		}
+	}
+}
+
+// line routes a single line printed by a coprocess, which outlives the jobs
+// so its output is not captured per job.
+func (o *outputWriter) line(fields logrus.Fields, stream string, output *config.Output, line string) {
+	entry := logrus.WithFields(fields).WithField("stream", stream)
+	switch output.Kind {
+	case config.OutputDiscard:
+	case config.OutputLog:
+		entry.WithField("line", line).Log(output.Level, "Coprocess output")
+	case config.OutputFile:
+		if err := o.appendTo(output.Path, []byte(line+"\n")); err != nil {
+			entry.WithError(err).Error("Cant write coprocess output to a file")
+		}
+	case config.OutputRotate:
+		if err := o.appendRotating(output.Path, []byte(line+"\n")); err != nil {
+			entry.WithError(err).Error("Cant write coprocess output to a rotating log")
+		}
+	}
+}
+
+func (o *outputWriter) appendTo(path string, data []byte) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return appendToFile(path, data)
+}
+
+func (o *outputWriter) appendRotating(path string, data []byte) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if fi, err := os.Stat(path); err == nil && fi.Size()+int64(len(data)) > rotateMaxSize {
+		if err := rotate(path); err != nil {
+			return fmt.Errorf("cant rotate %s: %w", path, err)
+		}
+	}
+	return appendToFile(path, data)
+}
+
+func rotate(path string) error {
+	for i := rotateBackups - 1; i >= 1; i-- {
+		from := path + "." + strconv.Itoa(i)
+		if _, err := os.Stat(from); err != nil {
+			continue
+		}
+		if err := os.Rename(from, path+"."+strconv.Itoa(i+1)); err != nil {
+			return fmt.Errorf("cant rename backup: %w", err)
+		}
+	}
+	if err := os.Rename(path, path+".1"); err != nil {
+		return fmt.Errorf("cant rename log: %w", err)
+	}
+	return nil
+}
+
+func appendToFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return fmt.Errorf("cant create output dir: %w", err)
+	}
+	// nolint:gosec
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("cant open %s: %w", path, err)
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("cant write to %s: %w", path, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("cant close %s: %w", path, err)
+	}
+	return nil
+}
+
+// writerOrNull avoids wrapping a nil buffer in a non-nil interface, a nil
+// writer connects the stream to the null device.
+func writerOrNull(captured *cappedBuffer) io.Writer {
+	if captured == nil {
+		return nil
+	}
+	return captured
+}
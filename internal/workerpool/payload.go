@@ -0,0 +1,51 @@
+package workerpool
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+type PayloadEvent struct {
+	Type    string    `json:"type"`
+	Context string    `json:"context"`
+	Time    time.Time `json:"time"`
+}
+
+// Payload is the structured description of a job execution, written to
+// the command's stdin with `stdin = "json"`.
+type Payload struct {
+	Event       PayloadEvent `json:"event"`
+	Captures    []string     `json:"captures"`
+	JobID       string       `json:"job_id"`
+	Handler     string       `json:"handler"`
+	HandlerName string       `json:"handler_name"`
+	RoutingKey  string       `json:"routing_key"`
+	ConfigDir   string       `json:"config_dir"`
+	Attempt     int          `json:"attempt"`
+}
+
+func (j *Job) payload(attempt int) *Payload {
+	return &Payload{
+		Event: PayloadEvent{
+			Type:    j.Trigger.EventType,
+			Context: j.Trigger.EventContext,
+			Time:    j.Trigger.EventTime,
+		},
+		Captures:    j.Trigger.Captures,
+		JobID:       j.ID.String(),
+		Handler:     j.Handler,
+		HandlerName: j.Name,
+		RoutingKey:  j.RoutingKey,
+		ConfigDir:   j.Trigger.ConfigDir,
+		Attempt:     attempt,
+	}
+}
+
+func (j *Job) payloadJSON(attempt int) ([]byte, error) {
+	data, err := json.Marshal(j.payload(attempt))
+	if err != nil {
+		return nil, fmt.Errorf("cant marshal payload: %w", err)
+	}
+	return data, nil
+}
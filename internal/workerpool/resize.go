@@ -0,0 +1,137 @@
+package workerpool
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+// handoff passes the jobs queued before a resize to the new workers. The new
+// workers wait for ready and run their backlog before their queue, so the
+// jobs keep their order even if new jobs are submitted in the meantime.
+type handoff struct {
+	ready   chan struct{}
+	backlog [][]*Job
+}
+
+func newHandoff(workers int) *handoff {
+	return &handoff{ready: make(chan struct{}), backlog: make([][]*Job, workers)}
+}
+
+// readyHandoff is a handoff without a backlog, for workers of a fresh pool.
+func readyHandoff(workers int) *handoff {
+	h := newHandoff(workers)
+	close(h.ready)
+	return h
+}
+
+// resize rebuilds the queues and workers when the desired size changed.
+// The queues are swapped right away, so submissions are not blocked while the
+// current workers finish their in-flight jobs. The jobs left in the old
+// queues are then handed to the new workers in their original order. Jobs of
+// a routing key always share a queue, so their relative order is kept across
+// the resize. Nothing is resized once the pool is shutting down.
+func (s *Service) resize() {
+	s.resizeMu.Lock()
+	defer s.resizeMu.Unlock()
+	workers, queueSize := s.size()
+
+	s.mu.Lock()
+	if workers == s.workers && queueSize == s.queueSize {
+		s.mu.Unlock()
+		return
+	}
+	select {
+	case <-s.closed:
+		s.mu.Unlock()
+		return
+	default:
+	}
+	// Once the run context is cancelled the pool is shutting down, the group
+	// might have been waited for already and new workers would outlive it.
+	if s.runCtx != nil && s.runCtx.Err() != nil {
+		s.mu.Unlock()
+		return
+	}
+
+	fields := logrus.Fields{
+		"workers": workers, "queue_size": queueSize,
+		"previous_workers": s.workers, "previous_queue_size": s.queueSize,
+	}
+	logrus.WithFields(fields).Info("Resizing the worker pool")
+
+	oldQueues, oldHandoff := s.workerQueues, s.handoff
+	oldStop, oldDone := s.stopWorkers, s.workersDone
+	s.workers, s.queueSize = workers, queueSize
+	s.workerQueues = newQueues(workers, queueSize)
+
+	if s.eg == nil {
+		// Nothing runs before the pool is started, the jobs are moved right away.
+		pending := takePending(nil, oldQueues)
+		for _, job := range pending {
+			workerIndex, err := s.workerIndex(job)
+			if err != nil {
+				logrus.WithError(err).WithField("id", job.ID).Error("Cant requeue a job, dropping")
+				continue
+			}
+			select {
+			case s.workerQueues[workerIndex] <- job:
+			default:
+				logrus.WithField("id", job.ID).Error("Queue is full before the pool is started, dropping the job")
+			}
+		}
+		s.mu.Unlock()
+		logrus.WithFields(fields).WithField("requeued", len(pending)).Info("Worker pool resized")
+		return
+	}
+
+	close(oldStop)
+	next := newHandoff(workers)
+	s.startWorkers(next)
+	runCtx := s.runCtx
+	s.mu.Unlock()
+
+	oldDone.Wait()
+	if runCtx.Err() != nil {
+		// The new workers exit without waiting for the handoff.
+		logrus.WithFields(fields).Debug("Pool is shutting down, dropping the jobs queued before the resize")
+		return
+	}
+	pending := takePending(oldHandoff, oldQueues)
+	for _, job := range pending {
+		workerIndex, err := WorkerIndex(job.RoutingKey, workers)
+		if err != nil {
+			logrus.WithError(err).WithField("id", job.ID).Error("Cant requeue a job, dropping")
+			continue
+		}
+		next.backlog[workerIndex] = append(next.backlog[workerIndex], job)
+	}
+	close(next.ready)
+	logrus.WithFields(fields).WithField("requeued", len(pending)).Info("Worker pool resized")
+}
+
+// takePending collects the jobs that the stopped workers did not run, the
+// backlog that they did not get to comes before their queues.
+func takePending(previous *handoff, queues []chan *Job) []*Job {
+	pending := []*Job{}
+	if previous != nil {
+		for _, backlog := range previous.backlog {
+			pending = append(pending, backlog...)
+		}
+	}
+	for _, queue := range queues {
+		pending = append(pending, drain(queue)...)
+	}
+	return pending
+}
+
+// drain takes all the jobs that are currently in the queue.
+func drain(queue chan *Job) []*Job {
+	jobs := []*Job{}
+	for {
+		select {
+		case job := <-queue:
+			jobs = append(jobs, job)
+		default:
+			return jobs
+		}
+	}
+}
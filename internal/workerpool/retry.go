@@ -0,0 +1,70 @@
+package workerpool
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"time"
+
+	"github.com/fiffeek/hyprwhenthen/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+const maxRetryBackoff = time.Minute
+
+// executeWithRetries runs the job on the current worker until it succeeds or
+// the retry budget is exhausted. Retrying in place (instead of re-submitting)
+// keeps the ordering guarantees of the job's routing key.
+func (s *Service) executeWithRetries(ctx context.Context, job *Job) (int, error) {
+	attempt := 1
+	for {
+		err := s.executeJob(ctx, job, attempt)
+		if err == nil || attempt > job.Retries || ctx.Err() != nil || !job.shouldRetry(err) {
+			return attempt, err
+		}
+
+		backoff := retryBackoff(job.RetryBackoff, attempt)
+		logrus.WithError(err).WithFields(logrus.Fields{
+			"id": job.ID, "handler": job.Name, "attempt": attempt, "backoff": backoff,
+		}).Warn("Job failed, retrying")
+
+		select {
+		case <-ctx.Done():
+			return attempt, context.Cause(ctx)
+		case <-time.After(backoff):
+		}
+		attempt++
+	}
+}
+
+func (j *Job) shouldRetry(err error) bool {
+	if len(j.RetryOn) == 0 {
+		return true
+	}
+	for _, condition := range j.RetryOn {
+		if matchesRetryCondition(condition, err) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesRetryCondition(condition config.RetryCondition, err error) bool {
+	if condition.Timeout {
+		return errors.Is(err, context.DeadlineExceeded)
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return false
+	}
+	return condition.ExitCode == exitErr.ExitCode()
+}
+
+// retryBackoff doubles the base backoff with every attempt, capped at maxRetryBackoff.
+func retryBackoff(base time.Duration, attempt int) time.Duration {
+	backoff := base
+	for i := 1; i < attempt && backoff < maxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, maxRetryBackoff)
+}
@@ -0,0 +1,67 @@
+package workerpool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/fiffeek/hyprwhenthen/internal/hypr"
+	"github.com/fiffeek/hyprwhenthen/internal/script"
+)
+
+// executeScript runs a Starlark handler in-process, commands started with the
+// `run` built-in get the same environment as regular jobs. Dispatches go to
+// the instance of the session environment, which follows Hyprland restarts.
+func (s *Service) executeScript(ctx context.Context, job *Job, env []string, maxOutputSize int) error {
+	vars := map[string]string{}
+	for _, entry := range env {
+		key, value, _ := strings.Cut(entry, "=")
+		vars[key] = value
+	}
+	input := &script.Input{
+		EventType:    job.Trigger.EventType,
+		EventContext: job.Trigger.EventContext,
+		EventTime:    job.Trigger.EventTime,
+		Captures:     job.Trigger.Captures,
+		Env:          vars,
+		Handler:      job.Name,
+	}
+	runtime := &script.Runtime{
+		State: s.state,
+		Run: func(ctx context.Context, command string) (*script.RunResult, error) {
+			return runCommand(ctx, command, env, job.Workdir, maxOutputSize)
+		},
+		Dispatch:  hypr.Dispatch,
+		Signature: getenv(s.env.Environ(), hypr.SignatureEnvVar),
+	}
+	if err := job.Script.Exec(ctx, input, runtime); err != nil {
+		return fmt.Errorf("cant execute script: %w", err)
+	}
+	return nil
+}
+
+func runCommand(ctx context.Context, command string, env []string, workdir string, maxOutputSize int) (*script.RunResult, error) {
+	// nolint:gosec
+	cmd := exec.CommandContext(ctx, "bash", "-c", command)
+	cmd.Env = env
+	cmd.Dir = workdir
+	stdout, stderr := newCappedBuffer(maxOutputSize), newCappedBuffer(maxOutputSize)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	result := &script.RunResult{}
+	err := cmd.Run()
+	result.Stdout = string(stdout.Bytes())
+	result.Stderr = string(stderr.Bytes())
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && ctx.Err() == nil {
+		result.Code = exitErr.ExitCode()
+		return result, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cant run %s: %w", command, err)
+	}
+	return result, nil
+}
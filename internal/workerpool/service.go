@@ -2,50 +2,121 @@
 package workerpool
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"hash/fnv"
-	"os"
 	"os/exec"
 	"sync"
+	"sync/atomic"
 
 	"github.com/fiffeek/hyprwhenthen/internal/config"
+	"github.com/fiffeek/hyprwhenthen/internal/script"
 
 	"github.com/sirupsen/logrus"
 	"golang.org/x/sync/errgroup"
 )
 
 type Service struct {
-	workers      int
-	workerQueues []chan *Job
-	cfg          *config.Config
-	results      chan *Result
-	closed       chan struct{}
-	startOnce    sync.Once
-	closeOnce    sync.Once
+	// mu guards the queues and workers, they are swapped when the pool is resized.
+	mu                sync.RWMutex
+	workers           int
+	queueSize         int
+	workerQueues      []chan *Job
+	stopWorkers       chan struct{}
+	workersDone       *sync.WaitGroup
+	handoff           *handoff
+	workersOverride   *int
+	queueSizeOverride *int
+	runCtx            context.Context
+	eg                *errgroup.Group
+	cfg               *config.Config
+	results           chan *Result
+	closed            chan struct{}
+	startOnce         sync.Once
+	closeOnce         sync.Once
+	outputs           *outputWriter
+	env               EnvProvider
+	coprocesses       *coprocessManager
+	state             *script.Store
+	guards            *guardCache
+	// dryRunMode is the mode last announced, to log when it is switched.
+	dryRunMode atomic.Bool
+	// dryRunOverride is the initial mode set by the flag, configDryRun the
+	// general.dry_run of the last config.
+	dryRunOverride atomic.Pointer[bool]
+	configDryRun   atomic.Bool
+	// resizeMu serializes resizes, the backlog of the workers started by one
+	// is handed over before the next one stops them.
+	resizeMu sync.Mutex
 }
 
-func NewService(workersNum, queueSize int, cfg *config.Config) (*Service, error) {
-	if workersNum <= 0 {
+// EnvProvider provides the base environment for job executions.
+type EnvProvider interface {
+	Environ() []string
+}
+
+// NewService creates a pool sized according to the general config section,
+// non-nil overrides (e.g. from CLI flags) take precedence over the config.
+func NewService(cfg *config.Config, env EnvProvider, workersOverride, queueSizeOverride *int, dryRunOverride *bool) (*Service, error) {
+	if workersOverride != nil && *workersOverride <= 0 {
 		return nil, errors.New("workersNum has to be > 0")
 	}
-	if queueSize < 0 {
+	if queueSizeOverride != nil && *queueSizeOverride < 0 {
 		return nil, errors.New("queue must be >= 0")
 	}
 
-	workerQueues := make([]chan *Job, workersNum)
-	for i := range workersNum {
-		workerQueues[i] = make(chan *Job, queueSize)
+	outputs := &outputWriter{}
+	s := &Service{
+		workersOverride:   workersOverride,
+		queueSizeOverride: queueSizeOverride,
+		closed:            make(chan struct{}),
+		cfg:               cfg,
+		outputs:           outputs,
+		env:               env,
+		coprocesses:       newCoprocessManager(outputs),
+		state:             script.NewStore(),
+		guards:            newGuardCache(),
 	}
+	s.dryRunOverride.Store(dryRunOverride)
+	s.configDryRun.Store(*cfg.Get().General.DryRun)
+	s.workers, s.queueSize = s.size()
+	s.workerQueues = newQueues(s.workers, s.queueSize)
+	s.results = make(chan *Result, s.queueSize*s.workers)
+	s.announceDryRun()
+	return s, nil
+}
 
-	return &Service{
-		workers:      workersNum,
-		workerQueues: workerQueues,
-		closed:       make(chan struct{}),
-		results:      make(chan *Result, queueSize*workersNum),
-		cfg:          cfg,
-	}, nil
+// size returns the desired number of workers and queue size.
+func (s *Service) size() (int, int) {
+	general := s.cfg.Get().General
+	workers, queueSize := *general.Workers, *general.QueueSize
+	if s.workersOverride != nil {
+		workers = *s.workersOverride
+	}
+	if s.queueSizeOverride != nil {
+		queueSize = *s.queueSizeOverride
+	}
+	return workers, queueSize
+}
+
+// Environ returns the session environment that jobs are started with.
+func (s *Service) Environ() []string {
+	return s.env.Environ()
+}
+
+// State returns the store that scripts keep their state in.
+func (s *Service) State() *script.Store {
+	return s.state
+}
+
+func newQueues(workers, queueSize int) []chan *Job {
+	queues := make([]chan *Job, workers)
+	for i := range workers {
+		queues[i] = make(chan *Job, queueSize)
+	}
+	return queues
 }
 
 func (s *Service) Listen() <-chan *Result {
@@ -59,12 +130,14 @@ func (s *Service) Submit(ctx context.Context, job *Job) error {
 	default:
 	}
 
-	logrus.WithFields(logrus.Fields{"id": job.ID, "routing_key": job.RoutingKey}).Debug("Queuing a job")
-	workerIndex, err := s.hashRoutingKey(job.RoutingKey)
+	// Holding the read lock blocks resizing until the job is queued.
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	logrus.WithFields(logrus.Fields{"id": job.ID, "handler": job.Name, "routing_key": job.RoutingKey}).Debug("Queuing a job")
+	workerIndex, err := s.workerIndex(job)
 	if err != nil {
-		return fmt.Errorf("cant calculate worker index: %w", err)
+		return err
 	}
-	workerIndex %= len(s.workerQueues)
 	logrus.WithFields(logrus.Fields{"id": job.ID, "worker": workerIndex}).Debug("Assigned the job to a worker")
 
 	select {
@@ -75,8 +148,23 @@ func (s *Service) Submit(ctx context.Context, job *Job) error {
 	}
 }
 
+func (s *Service) workerIndex(job *Job) (int, error) {
+	return WorkerIndex(job.RoutingKey, len(s.workerQueues))
+}
+
+// WorkerIndex returns the worker that jobs with the routing key are queued to.
+func WorkerIndex(routingKey string, workers int) (int, error) {
+	workerIndex, err := hashRoutingKey(routingKey)
+	if err != nil {
+		return 0, fmt.Errorf("cant calculate worker index: %w", err)
+	}
+	return workerIndex % workers, nil
+}
+
 func (s *Service) Stop() {
 	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
 		close(s.closed)
 		for _, queue := range s.workerQueues {
 			close(queue)
@@ -88,60 +176,159 @@ func (s *Service) Run(ctx context.Context) error {
 	var err error
 	s.startOnce.Do(func() {
 		eg, ctx := errgroup.WithContext(ctx)
-		for i := 0; i < s.workers; i++ {
-			eg.Go(func() error { return s.runWorker(ctx, i) })
-		}
+		// Keeps the group alive while the workers are replaced during a resize.
+		eg.Go(func() error {
+			select {
+			case <-ctx.Done():
+				return context.Cause(ctx)
+			case <-s.closed:
+				return nil
+			}
+		})
+		s.mu.Lock()
+		s.runCtx, s.eg = ctx, eg
+		s.startWorkers(readyHandoff(s.workers))
+		s.mu.Unlock()
 		err = eg.Wait()
+		s.coprocesses.stopAll()
 		close(s.results)
 	})
 	return err
 }
 
-func (s *Service) runWorker(ctx context.Context, workerID int) error {
+// startWorkers starts a worker per queue, has to be called with mu held.
+func (s *Service) startWorkers(handoff *handoff) {
+	ctx, stop, done := s.runCtx, make(chan struct{}), &sync.WaitGroup{}
+	s.stopWorkers, s.workersDone, s.handoff = stop, done, handoff
+	for i, queue := range s.workerQueues {
+		done.Add(1)
+		s.eg.Go(func() error {
+			defer done.Done()
+			return s.runWorker(ctx, i, queue, stop, handoff)
+		})
+	}
+}
+
+func (s *Service) runWorker(ctx context.Context, workerID int, jobQueue <-chan *Job, stop <-chan struct{},
+	handoff *handoff,
+) error {
 	defer func() {
 		if r := recover(); r != nil {
 			logrus.Errorf("worker %d panic: %v", workerID, r)
 		}
 	}()
 
-	jobQueue := s.workerQueues[workerID]
+	// The jobs queued before a resize run first, the ones that are not run
+	// before the worker is stopped are left to the next resize.
+	select {
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	case <-handoff.ready:
+	}
+	for len(handoff.backlog[workerID]) > 0 {
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+		job := handoff.backlog[workerID][0]
+		handoff.backlog[workerID] = handoff.backlog[workerID][1:]
+		if err := s.runAndReport(ctx, job); err != nil {
+			return err
+		}
+	}
+
 	for {
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
 		select {
 		case <-ctx.Done():
 			return context.Cause(ctx)
+		case <-stop:
+			return nil
 		case job, ok := <-jobQueue:
 			if !ok {
 				return nil
 			}
-			err := s.executeJob(ctx, job)
-			select {
-			case s.results <- &Result{JobID: job.ID, Err: err, Exec: job.Exec}:
-			case <-ctx.Done():
-				return context.Cause(ctx)
+			if err := s.runAndReport(ctx, job); err != nil {
+				return err
 			}
 		}
 	}
 }
 
-func (s *Service) executeJob(ctx context.Context, job *Job) error {
+func (s *Service) runAndReport(ctx context.Context, job *Job) error {
+	result := s.runJob(ctx, job)
+	select {
+	case s.results <- result:
+		return nil
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+}
+
+// runJob executes the job unless it went stale or its check did not pass.
+func (s *Service) runJob(ctx context.Context, job *Job) *Result {
+	result := &Result{JobID: job.ID, Exec: job.Exec, Handler: job.Name}
+	if s.stale(job) {
+		logrus.WithFields(logrus.Fields{
+			"id": job.ID, "handler": job.Name, "generation": job.Generation,
+		}).Info("Handler was removed or changed by a reload, dropping the queued job")
+		result.Stale = true
+		return result
+	}
+	job.dryRun = s.dryRun()
+	result.DryRun = job.dryRun
+	passed, err := s.guard(ctx, job)
+	switch {
+	case err != nil:
+		result.Err = err
+	case !passed:
+		result.Skipped = true
+	default:
+		result.Attempts, result.Err = s.executeWithRetries(ctx, job)
+	}
+	return result
+}
+
+func (s *Service) executeJob(ctx context.Context, job *Job, attempt int) error {
+	general := s.cfg.Get().General
 	timeout := job.Timeout
 	if timeout == nil {
-		timeout = s.cfg.Get().General.Timeout
+		timeout = general.Timeout
+	}
+	maxOutputSize := job.MaxOutputSize
+	if maxOutputSize == nil {
+		maxOutputSize = general.MaxOutputSize
+	}
+
+	if job.dryRun {
+		s.logDryRun(job, attempt, *timeout)
+		return nil
+	}
+	if job.Coprocess != "" {
+		return s.coprocesses.send(ctx, job, job.coprocessEnviron(s.env.Environ()), attempt, *timeout)
 	}
 
 	jobCtx, cancel := context.WithTimeout(ctx, *timeout)
 	defer cancel()
-	// nolint: gosec
-	cmd := exec.CommandContext(jobCtx, "bash", "-c", job.Exec)
-	env := append([]string{}, os.Environ()...)
-	for key, value := range job.extraEnv {
-		env = append(env, key+"="+value)
+	if job.Script != nil {
+		err := s.executeScript(jobCtx, job, job.environ(s.env.Environ(), attempt), *maxOutputSize)
+		if err != nil {
+			if jobCtx.Err() != nil {
+				return context.Cause(jobCtx)
+			}
+			return fmt.Errorf("job %s errored: %w", job.ID, err)
+		}
+		return nil
 	}
-	cmd.Env = env
-
-	out, err := cmd.CombinedOutput()
-	logrus.Debugf("Command %s output %s", job.Exec, string(out))
-	if err != nil {
+	if len(job.Steps) > 0 {
+		return s.executeSteps(ctx, job, attempt, *timeout, *maxOutputSize)
+	}
+	if _, err := s.runShell(jobCtx, job, job.Exec, job.environ(s.env.Environ(), attempt), attempt, *maxOutputSize); err != nil {
 		if jobCtx.Err() != nil {
 			return context.Cause(jobCtx)
 		}
@@ -151,8 +338,62 @@ func (s *Service) executeJob(ctx context.Context, job *Job) error {
 	return nil
 }
 
-func (s *Service) hashRoutingKey(routingKey string) (int, error) {
+// runShell runs a command of the job with bash and routes its outputs,
+// the captured stdout is returned as well.
+func (s *Service) runShell(ctx context.Context, job *Job, command string, env []string, attempt, maxOutputSize int) ([]byte, error) {
+	// nolint: gosec
+	cmd := exec.CommandContext(ctx, "bash", "-c", command)
+	cmd.Env = env
+	cmd.Dir = job.Workdir
+	if job.Stdin == config.StdinJSON {
+		payload, err := job.payloadJSON(attempt)
+		if err != nil {
+			return nil, fmt.Errorf("cant prepare stdin for job %s: %w", job.ID, err)
+		}
+		cmd.Stdin = bytes.NewReader(append(payload, '\n'))
+	}
+
+	stdout := s.outputs.capture(job.Stdout, maxOutputSize)
+	if stdout == nil && len(job.Steps) > 0 {
+		// Step outputs are passed to the following steps even when discarded.
+		stdout = newCappedBuffer(maxOutputSize)
+	}
+	stderr := s.outputs.capture(job.Stderr, maxOutputSize)
+	cmd.Stdout = writerOrNull(stdout)
+	cmd.Stderr = writerOrNull(stderr)
+
+	err := cmd.Run()
+	s.outputs.flush(job, "stdout", job.Stdout, stdout)
+	s.outputs.flush(job, "stderr", job.Stderr, stderr)
+	if stdout == nil {
+		return nil, err
+	}
+	return stdout.Bytes(), err
+}
+
+// OnConfigReload restarts the coprocesses so that they pick up config changes,
+// drops the cached check results, resizes the pool and switches the dry run mode.
+func (s *Service) OnConfigReload(context.Context) error {
+	s.coprocesses.stopAll()
+	s.guards.clear()
+	s.resize()
+	s.followConfigDryRun()
+	s.announceDryRun()
+	return nil
+}
+
+func hashRoutingKey(routingKey string) (int, error) {
 	h := fnv.New32a()
 	_, err := h.Write([]byte(routingKey))
 	return int(h.Sum32()), err
 }
+
+// stale reports whether the job should be dropped, i.e. it was queued before
+// a reload that removed or changed its handler and the handler opted in.
+func (s *Service) stale(job *Job) bool {
+	if job.OnReload != config.OnReloadCancel {
+		return false
+	}
+	cfg := s.cfg.Get()
+	return job.Generation != cfg.Generation && !cfg.HasHandler(job.Fingerprint)
+}
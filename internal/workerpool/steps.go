@@ -0,0 +1,64 @@
+package workerpool
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fiffeek/hyprwhenthen/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	StepOutputPrefix = "STEP_"
+	StepOutputSuffix = "_OUTPUT"
+)
+
+// Step is a single command of a multi-step job.
+type Step struct {
+	Run       string
+	Timeout   *time.Duration
+	OnFailure string
+}
+
+func stepOutputEnvVar(index int) string {
+	return StepOutputPrefix + strconv.Itoa(index) + StepOutputSuffix
+}
+
+// executeSteps runs the steps of the job sequentially, each with its own
+// timeout (defaulting to the handler one). The trimmed stdout of every step is
+// exposed to the following steps as $STEP_<n>_OUTPUT.
+func (s *Service) executeSteps(ctx context.Context, job *Job, attempt int, timeout time.Duration, maxOutputSize int) error {
+	env := job.environ(s.env.Environ(), attempt)
+	for i, step := range job.Steps {
+		stepTimeout := timeout
+		if step.Timeout != nil {
+			stepTimeout = *step.Timeout
+		}
+		output, err := s.executeStep(ctx, job, step, env, attempt, stepTimeout, maxOutputSize)
+		env = append(env, stepOutputEnvVar(i)+"="+strings.TrimRight(string(output), "\n"))
+		if err == nil {
+			continue
+		}
+		if step.OnFailure == config.OnFailureContinue && ctx.Err() == nil {
+			logrus.WithError(err).WithFields(logrus.Fields{"id": job.ID, "handler": job.Name, "step": i}).Warn("Step failed, continuing")
+			continue
+		}
+		return fmt.Errorf("job %s step %d errored: %w", job.ID, i, err)
+	}
+	return nil
+}
+
+func (s *Service) executeStep(ctx context.Context, job *Job, step Step, env []string, attempt int,
+	timeout time.Duration, maxOutputSize int,
+) ([]byte, error) {
+	stepCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	output, err := s.runShell(stepCtx, job, step.Run, env, attempt, maxOutputSize)
+	if err != nil && stepCtx.Err() != nil {
+		return output, context.Cause(stepCtx)
+	}
+	return output, err
+}
@@ -1,46 +1,209 @@
 package workerpool
 
 import (
+	"fmt"
+	"maps"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/fiffeek/hyprwhenthen/internal/utils"
+	"github.com/fiffeek/hyprwhenthen/internal/config"
+	"github.com/fiffeek/hyprwhenthen/internal/script"
+	"github.com/fiffeek/hyprwhenthen/internal/tmpl"
 	"github.com/google/uuid"
 )
 
 type Result struct {
-	JobID uuid.UUID
-	Err   error
-	Exec  string
+	JobID    uuid.UUID
+	Err      error
+	Exec     string
+	Attempts int
+	Handler  string
+	// Skipped is set when the check of the job did not pass.
+	Skipped bool
+	// Stale is set when the job was dropped because its handler changed.
+	Stale bool
+	// DryRun is set when the job was logged instead of executed.
+	DryRun bool
+}
+
+// Trigger describes the event that caused a job.
+type Trigger struct {
+	EventType    string
+	EventContext string
+	EventTime    time.Time
+	// Captures are the regex matches, the full match first.
+	Captures  []string
+	ConfigDir string
+	// ConfigPath is the main config file.
+	ConfigPath string
+	// Generation is the config generation that the event was matched against.
+	Generation uint64
 }
 
 type Job struct {
-	ID         uuid.UUID
-	RoutingKey string
-	extraEnv   map[string]string
-	Exec       string
-	Timeout    *time.Duration
+	ID            uuid.UUID
+	RoutingKey    string
+	extraEnv      map[string]string
+	Exec          string
+	Timeout       *time.Duration
+	Retries       int
+	RetryBackoff  time.Duration
+	RetryOn       []config.RetryCondition
+	Stdout        *config.Output
+	Stderr        *config.Output
+	MaxOutputSize *int
+	Env           map[string]string
+	InheritEnv    bool
+	EnvAllowlist  []string
+	Workdir       string
+	Stdin         string
+	Coprocess     string
+	Steps         []Step
+	Script        *script.Program
+	Check         string
+	CheckTimeout  time.Duration
+	CheckCacheTTL time.Duration
+	// Handler is the index of the handler, Name its label.
+	Handler string
+	Name    string
+	Trigger *Trigger
+	// Fingerprint identifies the handler definition, OnReload decides what
+	// happens to the job when the handler is gone by the time it runs.
+	Fingerprint string
+	OnReload    string
+	Generation  uint64
+	// dryRun is decided when the job is picked up by a worker.
+	dryRun bool
 }
 
-func NewJob(env map[string]string, exec string, timeout *time.Duration, maybeRoutingKey *string) *Job {
-	routingKey := maybeRoutingKey
+// NewJob creates the job of the handler, base is the session environment the
+// job will be started with, templates see the same variables as the command.
+func NewJob(trigger *Trigger, handler *config.Event, base []string) (*Job, error) {
 	jobID := uuid.New()
-	if routingKey == nil {
-		routingKey = utils.JustPtr(jobID.String())
-	} else {
-		routingKey = utils.JustPtr(os.Expand(*routingKey, func(key string) string {
-			value, ok := env[key]
-			if !ok {
-				return os.Getenv(key)
-			}
-			return value
-		}))
-	}
-	return &Job{
-		extraEnv:   env,
-		Exec:       exec,
-		ID:         jobID,
-		Timeout:    timeout,
-		RoutingKey: *routingKey,
+	handlerID := strconv.Itoa(handler.Index)
+	job := &Job{
+		extraEnv:      eventEnv(trigger, jobID.String(), handler.Label()),
+		Exec:          handler.Then,
+		ID:            jobID,
+		Timeout:       handler.Timeout,
+		RoutingKey:    jobID.String(),
+		RetryOn:       handler.RetryConditions,
+		Stdout:        handler.StdoutOutput,
+		Stderr:        handler.StderrOutput,
+		MaxOutputSize: handler.MaxOutputSize,
+		Env:           map[string]string{},
+		InheritEnv:    handler.InheritsEnv(),
+		EnvAllowlist:  handler.EnvAllowlist,
+		Handler:       handlerID,
+		Name:          handler.Label(),
+		Trigger:       trigger,
+		Fingerprint:   handler.Fingerprint,
+		OnReload:      *handler.OnReload,
+		Generation:    trigger.Generation,
+	}
+	for _, step := range handler.Steps {
+		job.Steps = append(job.Steps, Step{Run: step.Run, Timeout: step.Timeout, OnFailure: *step.OnFailure})
+	}
+	maps.Copy(job.Env, handler.FileEnv)
+	maps.Copy(job.Env, handler.Env)
+	if handler.Workdir != nil {
+		job.Workdir = *handler.Workdir
+	}
+	if handler.TemplateEnabled() {
+		if err := job.render(handler, base); err != nil {
+			return nil, err
+		}
+	} else if handler.RoutingKey != nil {
+		job.RoutingKey = os.Expand(*handler.RoutingKey, job.lookupEnv(base))
+	}
+	job.extraEnv[RoutingKeyEnvVar] = job.RoutingKey
+	if len(job.Steps) > 0 {
+		job.Exec = job.stepsExec()
+	}
+	if handler.Stdin != nil {
+		job.Stdin = *handler.Stdin
+	}
+	job.Script = handler.Program
+	if job.Script != nil {
+		job.Exec = "starlark:" + job.Script.Name()
+	}
+	if handler.Coprocess != nil {
+		job.Coprocess = *handler.Coprocess
+		job.Exec = job.Coprocess
+	}
+	if handler.Check != nil {
+		job.Check = *handler.Check
+		job.CheckTimeout = *handler.CheckTimeout
+	}
+	if handler.CheckCacheTTL != nil {
+		job.CheckCacheTTL = *handler.CheckCacheTTL
+	}
+	if handler.Retries != nil {
+		job.Retries = *handler.Retries
+	}
+	if handler.RetryBackoff != nil {
+		job.RetryBackoff = *handler.RetryBackoff
+	}
+	return job, nil
+}
+
+// render executes the handler templates, the routing key is rendered first so
+// that it is not available to the routing key template itself.
+func (j *Job) render(handler *config.Event, base []string) error {
+	data := j.templateData(base)
+	if handler.RoutingKeyTemplate != nil {
+		routingKey, err := tmpl.Render(handler.RoutingKeyTemplate, data)
+		if err != nil {
+			return fmt.Errorf("cant render routing key: %w", err)
+		}
+		j.RoutingKey = routingKey
+		data.Env[RoutingKeyEnvVar] = routingKey
+	}
+	for i, step := range handler.Steps {
+		run, err := tmpl.Render(step.RunTemplate, data)
+		if err != nil {
+			return fmt.Errorf("cant render step %d: %w", i, err)
+		}
+		j.Steps[i].Run = run
+	}
+	if handler.ThenTemplate == nil {
+		return nil
+	}
+	exec, err := tmpl.Render(handler.ThenTemplate, data)
+	if err != nil {
+		return fmt.Errorf("cant render command: %w", err)
+	}
+	j.Exec = exec
+	return nil
+}
+
+func (j *Job) templateData(base []string) *tmpl.Data {
+	env := map[string]string{}
+	for _, entry := range j.eventEnviron(base) {
+		key, value, _ := strings.Cut(entry, "=")
+		env[key] = value
+	}
+	return &tmpl.Data{
+		Event: tmpl.Event{
+			Type:    j.Trigger.EventType,
+			Context: j.Trigger.EventContext,
+			Time:    j.Trigger.EventTime,
+		},
+		Captures:    j.Trigger.Captures,
+		Env:         env,
+		Handler:     j.Handler,
+		HandlerName: j.Name,
+		JobID:       j.ID.String(),
+	}
+}
+
+// stepsExec describes the steps in logs.
+func (j *Job) stepsExec() string {
+	runs := make([]string, 0, len(j.Steps))
+	for _, step := range j.Steps {
+		runs = append(runs, step.Run)
 	}
+	return strings.Join(runs, "; ")
 }
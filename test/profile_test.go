@@ -0,0 +1,79 @@
+package test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fiffeek/hyprwhenthen/internal/hypr"
+	"github.com/fiffeek/hyprwhenthen/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test__Profile(t *testing.T) {
+	xdgRuntimeDir, signature := testutils.SetupHyprEnvVars(t)
+	listenCtx, cancelListen := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelListen()
+	eventsListener, teardownEvents := testutils.SetupHyprSocket(listenCtx, t,
+		xdgRuntimeDir, signature, hypr.GetHyprEventsSocket)
+	defer teardownEvents()
+
+	tmpDir := t.TempDir()
+	extraEnv := prepTestEnv(tmpDir)
+	extraEnv["HWT_TEST_BINARY"] = filepath.Join(basepath, binaryPath)
+	configPath := "testdata/configs/should_switch_profiles.toml"
+
+	// runService runs the service until check returns, check can send events.
+	runService := func(check func(ctx context.Context, events chan<- string)) string {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		events := make(chan string)
+		fakeHyprEventServerDone := testutils.SetupLiveHyprEventsServer(ctx, t, eventsListener, events)
+
+		done := make(chan struct{})
+		var out []byte
+		go func() {
+			defer close(done)
+			cmd := prepBinaryRun(ctx, []string{"--config", configPath, "run"}, inlineEnv(extraEnv))
+			out, _ = cmd.CombinedOutput()
+		}()
+
+		check(ctx, events)
+		cancel()
+		waitFor(t, fakeHyprEventServerDone)
+		<-done
+		t.Log(string(out))
+		return string(out)
+	}
+
+	out := runService(func(ctx context.Context, events chan<- string) {
+		assert.NoError(t, waitForVersion(ctx, events, extraEnv["TMP_TST_FILE_0"], "docked"))
+		// The handler switches the profile through the CLI, which picks up the config from $HWT_CONFIG.
+		assert.NoError(t, sendEvent(ctx, events, "custom>>laptop"))
+		assert.NoError(t, waitForVersion(ctx, events, extraEnv["TMP_TST_FILE_0"], "laptop"))
+	})
+	assert.Contains(t, out, `msg="Active profiles" active="docked"`)
+	assert.Contains(t, out, `msg="Active profiles" active="laptop"`)
+
+	cmd := prepBinaryRun(context.Background(), []string{"--config", configPath, "profile", "list"}, inlineEnv(extraEnv))
+	list, err := cmd.Output()
+	require.NoError(t, err)
+	assert.Equal(t, "  docked  location  Three monitors\n  focus\n* laptop  location\n", string(list))
+
+	// A service started with another config keeps its own state.
+	otherConfig := copyConfig(t, configPath, tmpDir)
+	cmd = prepBinaryRun(context.Background(), []string{"--config", otherConfig, "profile", "list"}, inlineEnv(extraEnv))
+	list, err = cmd.Output()
+	require.NoError(t, err)
+	assert.Equal(t, "* docked  location  Three monitors\n  focus\n  laptop  location\n", string(list))
+
+	// The active profiles survive restarts.
+	require.NoError(t, os.Remove(extraEnv["TMP_TST_FILE_0"]))
+	out = runService(func(ctx context.Context, events chan<- string) {
+		assert.NoError(t, waitForVersion(ctx, events, extraEnv["TMP_TST_FILE_0"], "laptop"))
+	})
+	assert.Contains(t, out, `msg="Active profiles" active="laptop"`)
+}
@@ -0,0 +1,235 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fiffeek/hyprwhenthen/internal/hypr"
+	"github.com/fiffeek/hyprwhenthen/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// reloadConfig returns a config that appends the version to $TMP_TST_FILE_0
+// on every event.
+func reloadConfig(version string) []byte {
+	return fmt.Appendf(nil, `[general]
+timeout = "1s"
+hot_reload_debounce_timer = "10ms"
+
+[[handler]]
+on = "windowtitlev2"
+when = "(.*),(.*)"
+then = "echo %s >> $TMP_TST_FILE_0"
+`, version)
+}
+
+// withGeneral adds the given lines to the general section of the config.
+func withGeneral(config []byte, lines string) []byte {
+	return bytes.Replace(config, []byte("[general]\n"), []byte("[general]\n"+lines), 1)
+}
+
+// writeAtomically mimics editors (e.g. vim) that write a new file and
+// rename it over the original.
+func writeAtomically(t *testing.T, path, version string) {
+	tmp := path + ".tmp"
+	require.NoError(t, os.WriteFile(tmp, reloadConfig(version), 0o600))
+	require.NoError(t, os.Rename(tmp, path))
+}
+
+// swapSymlink mimics home-manager that replaces the link atomically.
+func swapSymlink(t *testing.T, link, target string) {
+	tmp := filepath.Join(filepath.Dir(link), ".tmp-link")
+	require.NoError(t, os.Symlink(target, tmp))
+	require.NoError(t, os.Rename(tmp, link))
+}
+
+func Test__Reload(t *testing.T) {
+	tests := []struct {
+		name string
+		// setup writes the first version of the config and returns the path to run with.
+		setup func(t *testing.T, dir string) string
+		// updates change the config to the given version, one after another.
+		updates           []func(t *testing.T, dir, version string)
+		expectLogsContain []string
+	}{
+		{
+			name: "should reload on atomic rename",
+			setup: func(t *testing.T, dir string) string {
+				path := filepath.Join(dir, "config.toml")
+				require.NoError(t, os.WriteFile(path, reloadConfig("v1"), 0o600))
+				return path
+			},
+			updates: []func(*testing.T, string, string){
+				func(t *testing.T, dir, version string) {
+					writeAtomically(t, filepath.Join(dir, "config.toml"), version)
+				},
+				func(t *testing.T, dir, version string) {
+					writeAtomically(t, filepath.Join(dir, "config.toml"), version)
+				},
+			},
+		},
+		{
+			name: "should reload on target edit",
+			setup: func(t *testing.T, dir string) string {
+				require.NoError(t, os.MkdirAll(filepath.Join(dir, "dotfiles"), 0o750))
+				require.NoError(t, os.MkdirAll(filepath.Join(dir, "config"), 0o750))
+				require.NoError(t, os.WriteFile(filepath.Join(dir, "dotfiles", "config.toml"), reloadConfig("v1"), 0o600))
+				link := filepath.Join(dir, "config", "config.toml")
+				require.NoError(t, os.Symlink("../dotfiles/config.toml", link))
+				return link
+			},
+			updates: []func(*testing.T, string, string){
+				func(t *testing.T, dir, version string) {
+					writeAtomically(t, filepath.Join(dir, "dotfiles", "config.toml"), version)
+				},
+				func(t *testing.T, dir, version string) {
+					require.NoError(t, os.WriteFile(filepath.Join(dir, "dotfiles", "config.toml"), reloadConfig(version), 0o600))
+				},
+			},
+		},
+		{
+			name: "should reload on symlink swap",
+			setup: func(t *testing.T, dir string) string {
+				require.NoError(t, os.MkdirAll(filepath.Join(dir, "store", "a"), 0o750))
+				require.NoError(t, os.MkdirAll(filepath.Join(dir, "config"), 0o750))
+				require.NoError(t, os.WriteFile(filepath.Join(dir, "store", "a", "config.toml"), reloadConfig("v1"), 0o600))
+				link := filepath.Join(dir, "config", "config.toml")
+				require.NoError(t, os.Symlink(filepath.Join(dir, "store", "a", "config.toml"), link))
+				return link
+			},
+			updates: []func(*testing.T, string, string){
+				func(t *testing.T, dir, version string) {
+					require.NoError(t, os.MkdirAll(filepath.Join(dir, "store", "b"), 0o750))
+					target := filepath.Join(dir, "store", "b", "config.toml")
+					require.NoError(t, os.WriteFile(target, reloadConfig(version), 0o600))
+					swapSymlink(t, filepath.Join(dir, "config", "config.toml"), target)
+				},
+				// The watch has to be re-armed on the new target directory.
+				func(t *testing.T, dir, version string) {
+					require.NoError(t, os.WriteFile(filepath.Join(dir, "store", "b", "config.toml"), reloadConfig(version), 0o600))
+				},
+			},
+		},
+		{
+			name: "should resize the pool",
+			setup: func(t *testing.T, dir string) string {
+				path := filepath.Join(dir, "config.toml")
+				require.NoError(t, os.WriteFile(path, reloadConfig("v1"), 0o600))
+				return path
+			},
+			updates: []func(*testing.T, string, string){
+				func(t *testing.T, dir, version string) {
+					require.NoError(t, os.WriteFile(filepath.Join(dir, "config.toml"),
+						withGeneral(reloadConfig(version), "workers = 4\nqueue_size = 1\n"), 0o600))
+				},
+				func(t *testing.T, dir, version string) {
+					require.NoError(t, os.WriteFile(filepath.Join(dir, "config.toml"),
+						withGeneral(reloadConfig(version), "workers = 1\n"), 0o600))
+				},
+			},
+			expectLogsContain: []string{
+				`queue_size="1" workers="4"`,
+				"Worker pool resized",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			xdgRuntimeDir, signature := testutils.SetupHyprEnvVars(t)
+			eventsListener, teardownEvents := testutils.SetupHyprSocket(ctx, t,
+				xdgRuntimeDir, signature, hypr.GetHyprEventsSocket)
+			defer teardownEvents()
+			events := make(chan string)
+			fakeHyprEventServerDone := testutils.SetupLiveHyprEventsServer(ctx, t, eventsListener, events)
+
+			tmpDir := t.TempDir()
+			extraEnv := prepTestEnv(tmpDir)
+			configDir := filepath.Join(tmpDir, "configs")
+			require.NoError(t, os.MkdirAll(configDir, 0o750))
+			configPath := tt.setup(t, configDir)
+
+			args := []string{"--config", configPath, "run"}
+			if *debug {
+				args = append(args, "--debug")
+			}
+
+			done := make(chan struct{})
+			var out []byte
+			go func() {
+				defer close(done)
+				cmd := prepBinaryRun(ctx, args, inlineEnv(extraEnv))
+				t.Log(cmd.Args)
+				out, _ = cmd.CombinedOutput()
+			}()
+
+			versions := []string{"v1"}
+			for i := range tt.updates {
+				versions = append(versions, fmt.Sprintf("v%d", i+2))
+			}
+			for i, version := range versions {
+				if i > 0 {
+					tt.updates[i-1](t, configDir, version)
+				}
+				assert.NoError(t, waitForVersion(ctx, events, extraEnv["TMP_TST_FILE_0"], version),
+					"config %s was not picked up", version)
+			}
+
+			cancel()
+			waitFor(t, fakeHyprEventServerDone)
+			<-done
+			t.Log(string(out))
+			for _, log := range tt.expectLogsContain {
+				assert.Contains(t, string(out), log)
+			}
+		})
+	}
+}
+
+// waitForVersion sends events until the handler of the given config version
+// handles one of them.
+func waitForVersion(ctx context.Context, events chan<- string, file, version string) error {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if err := sendEvent(ctx, events, "windowtitlev2>>558f74f82570,Mozilla Firefox"); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return errors.New("timed out")
+		case <-ticker.C:
+		}
+		// nolint:gosec
+		content, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+		if lines[len(lines)-1] == version {
+			return nil
+		}
+	}
+}
+
+// sendEvent hands the event to the live events server, the server stops
+// reading once the context is done.
+func sendEvent(ctx context.Context, events chan<- string, event string) error {
+	select {
+	case <-ctx.Done():
+		return errors.New("timed out")
+	case events <- event:
+		return nil
+	}
+}
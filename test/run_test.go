@@ -1,17 +1,20 @@
 package test
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/fiffeek/hyprwhenthen/internal/hypr"
 	"github.com/fiffeek/hyprwhenthen/internal/testutils"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func Test__Run_Binary(t *testing.T) {
@@ -25,6 +28,15 @@ func Test__Run_Binary(t *testing.T) {
 		validateSideEffects func(*testing.T, map[string]string)
 		waitForSideEffects  func(context.Context, *testing.T, map[string]string)
 		hyprEvents          []string
+		prepareRuntimeDir   func(*testing.T, string, string)
+		// copyConfig runs the binary with a copy of the config (exposed as
+		// HWT_TEST_CONFIG) so that the test can modify it.
+		copyConfig bool
+		// waitForLogs delays the cancellation until the logs contain all the
+		// substrings, use it for logs written after the side effects.
+		waitForLogs []string
+		// expectLogsNotContain lists the substrings that must not be logged.
+		expectLogsNotContain []string
 	}{
 		{
 			name:        "should show help",
@@ -58,6 +70,13 @@ func Test__Run_Binary(t *testing.T) {
 			expectError:         true,
 			expectErrorContains: "timeout must be positive",
 		},
+		{
+			name:                "should fail invalid workers",
+			config:              "testdata/configs/should_fail_invalid_workers.toml",
+			extraArgs:           []string{"validate"},
+			expectError:         true,
+			expectErrorContains: "workers must be positive",
+		},
 		{
 			name:                "should fail when handler timeout is negative",
 			config:              "testdata/configs/should_fail_negative_handler_timeout.toml",
@@ -338,140 +357,1433 @@ func Test__Run_Binary(t *testing.T) {
 				waitTillHolds(ctx, t, funcs, 400*time.Millisecond)
 			},
 		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			binaryStartingChan := make(chan struct{})
-			ctx, cancel := context.WithTimeout(context.Background(), 1000*time.Millisecond)
-			defer cancel()
-
-			// fake hypr ipc event socket
-			var fakeHyprEventServerDone chan struct{}
-			if len(tt.hyprEvents) > 0 {
-				xdgRuntimeDir, signature := testutils.SetupHyprEnvVars(t)
-				eventsListener, teardownEvents := testutils.SetupHyprSocket(ctx, t,
-					xdgRuntimeDir, signature, hypr.GetHyprEventsSocket)
-				defer teardownEvents()
-				fakeHyprEventServerDone = testutils.SetupFakeHyprEventsServer(ctx, t, eventsListener, tt.hyprEvents)
-			}
-
-			args := append([]string{
-				"--config", tt.config,
-			}, tt.extraArgs...)
-			if *debug {
-				args = append(args, "--debug")
-			}
-
-			done := make(chan struct{})
-			var out []byte
-			var binaryErr error
-			tmpDir := t.TempDir()
-			extraEnv := prepTestEnv(tmpDir)
-
-			go func() {
-				defer close(done)
-				cmd := prepBinaryRun(ctx, args, inlineEnv(extraEnv))
-				t.Log(cmd.Args)
-				close(binaryStartingChan)
-				out, binaryErr = cmd.CombinedOutput()
-			}()
-
-			if tt.waitForSideEffects != nil {
-				testutils.Logf(t, "Starting waitForSideEffects")
-				tt.waitForSideEffects(ctx, t, extraEnv)
-				testutils.Logf(t, "waitForSideEffects returned, calling cancel()")
-				cancel()
-			}
-
-			waitFor(t, fakeHyprEventServerDone)
-
-			select {
-			case <-time.After(1000 * time.Millisecond):
-				assert.True(t, false, "timeout while running, out: %s", string(out))
-			case <-done:
-				t.Log(string(out))
-				if tt.expectError {
-					assert.Error(t, binaryErr, "expected run to fail but it succeeded. Output: %s", string(out))
-					assert.Contains(t, string(out), tt.expectErrorContains,
-						"error message should contain expected substring. Got: %s", string(out))
-				} else {
-					assert.NoError(t, binaryErr, "expected to exit cleanly")
+		{
+			name:        "should retry failed jobs",
+			config:      "testdata/configs/should_retry.toml",
+			extraArgs:   []string{"run"},
+			expectError: true,
+			hyprEvents: []string{
+				"windowtitlev2>>558f74f82570,Mozilla Firefox",
+			},
+			validateSideEffects: func(t *testing.T, env map[string]string) {
+				testutils.AssertFileExists(t, env["TMP_TST_FILE_0"])
+				compareWithFixture(t, env["TMP_TST_FILE_0"],
+					"testdata/fixtures/should_retry")
+			},
+			waitForSideEffects: func(ctx context.Context, t *testing.T, env map[string]string) {
+				funcs := []func() error{
+					func() error {
+						return testutils.ContentSameAsFixture(t, env["TMP_TST_FILE_0"],
+							"testdata/fixtures/should_retry")
+					},
 				}
-				for _, expected := range tt.expectLogsContain {
-					assert.Contains(t, string(out), expected,
-						"combined logs should contain a substring")
+				waitTillHolds(ctx, t, funcs, 400*time.Millisecond)
+			},
+			waitForLogs: []string{
+				"attempts=\"3\"",
+			},
+			expectLogsContain: []string{
+				"attempts=\"3\"",
+			},
+		},
+		{
+			name:        "should not retry unlisted exit code",
+			config:      "testdata/configs/should_not_retry_unlisted_exit_code.toml",
+			extraArgs:   []string{"run", "--workers", "1"},
+			expectError: true,
+			hyprEvents: []string{
+				"windowtitlev2>>558f74f82570,Crash",
+				"windowtitlev2>>558f74f82570,Regular",
+			},
+			validateSideEffects: func(t *testing.T, env map[string]string) {
+				testutils.AssertFileExists(t, env["TMP_TST_FILE_0"])
+				compareWithFixture(t, env["TMP_TST_FILE_0"],
+					"testdata/fixtures/should_not_retry_unlisted_exit_code")
+			},
+			waitForSideEffects: func(ctx context.Context, t *testing.T, env map[string]string) {
+				funcs := []func() error{
+					func() error {
+						return testutils.ContentSameAsFixture(t, env["TMP_TST_FILE_0"],
+							"testdata/fixtures/should_not_retry_unlisted_exit_code")
+					},
 				}
-				if tt.validateSideEffects != nil {
-					tt.validateSideEffects(t, extraEnv)
+				waitTillHolds(ctx, t, funcs, 400*time.Millisecond)
+			},
+		},
+		{
+			name:                "should fail invalid retry_on",
+			config:              "testdata/configs/should_fail_invalid_retry_on.toml",
+			extraArgs:           []string{"validate"},
+			expectError:         true,
+			expectErrorContains: "retry_on is invalid",
+		},
+		{
+			name:        "should route output",
+			config:      "testdata/configs/should_route_output.toml",
+			extraArgs:   []string{"run"},
+			expectError: true,
+			hyprEvents: []string{
+				"windowtitlev2>>558f74f82570,Mozilla Firefox",
+			},
+			validateSideEffects: func(t *testing.T, env map[string]string) {
+				compareWithFixture(t, stateFile(env, "stdout.log"),
+					"testdata/fixtures/should_route_output__0")
+				compareWithFixture(t, stateFile(env, "capped.log"),
+					"testdata/fixtures/should_route_output__1")
+				testutils.AssertFileExists(t, stateFile(env, "handlers/handler-2.log"))
+			},
+			waitForSideEffects: func(ctx context.Context, t *testing.T, env map[string]string) {
+				funcs := []func() error{
+					func() error {
+						return testutils.ContentSameAsFixture(t, stateFile(env, "stdout.log"),
+							"testdata/fixtures/should_route_output__0")
+					},
+					func() error {
+						return testutils.ContentSameAsFixture(t, stateFile(env, "capped.log"),
+							"testdata/fixtures/should_route_output__1")
+					},
+					func() error {
+						return testutils.FileExists(stateFile(env, "handlers/handler-2.log"))
+					},
 				}
-			}
-		})
-	}
-}
-
-func inlineEnv(env map[string]string) []string {
-	extraEnv := []string{}
-	for key, value := range env {
-		extraEnv = append(extraEnv, fmt.Sprintf("%s=%s", key, value))
-	}
-	return extraEnv
-}
-
-func prepTestEnv(tmpDir string) map[string]string {
-	extraEnv := map[string]string{}
-	for i := range 10 {
-		file := filepath.Join(tmpDir, fmt.Sprintf("file_%d", i))
-		extraEnv[fmt.Sprintf("TMP_TST_FILE_%d", i)] = file
-	}
-	return extraEnv
-}
-
-func waitFor(t *testing.T, server chan struct{}) {
-	if server == nil {
-		return
-	}
-
-	select {
-	case <-server:
-	case <-time.After(800 * time.Millisecond):
-		assert.True(t, false, "Fake server didn't finish in time")
-	}
-}
-
-func waitTillHolds(ctx context.Context, t *testing.T, funcs []func() error, timeout time.Duration) {
-	deadline := time.Now().Add(timeout)
-	ticker := time.NewTicker(10 * time.Millisecond)
-	defer ticker.Stop()
-
-	testutils.Logf(t, "waitTillHolds starting, deadline in %v", timeout)
-
-	for {
-		select {
-		case <-ticker.C:
-			allPass := true
-			for _, f := range funcs {
-				if err := f(); err != nil {
-					allPass = false
-					break
+				waitTillHolds(ctx, t, funcs, 400*time.Millisecond)
+			},
+			expectLogsContain: []string{
+				"Job output exceeded max_output_size, truncated",
+			},
+		},
+		{
+			name:                "should fail invalid output destination",
+			config:              "testdata/configs/should_fail_invalid_output.toml",
+			extraArgs:           []string{"validate"},
+			expectError:         true,
+			expectErrorContains: "stdout is invalid",
+		},
+		{
+			name:        "should use handler env",
+			config:      "testdata/configs/should_use_handler_env.toml",
+			extraArgs:   []string{"run"},
+			expectError: true,
+			hyprEvents: []string{
+				"windowtitlev2>>558f74f82570,Mozilla Firefox",
+			},
+			validateSideEffects: func(t *testing.T, env map[string]string) {
+				compareWithFixture(t, env["TMP_TST_FILE_0"],
+					"testdata/fixtures/should_use_handler_env__0")
+				compareWithFixture(t, env["TMP_TST_FILE_1"],
+					"testdata/fixtures/should_use_handler_env__1")
+			},
+			waitForSideEffects: func(ctx context.Context, t *testing.T, env map[string]string) {
+				funcs := []func() error{
+					func() error {
+						return testutils.ContentSameAsFixture(t, env["TMP_TST_FILE_0"],
+							"testdata/fixtures/should_use_handler_env__0")
+					},
+					func() error {
+						return testutils.ContentSameAsFixture(t, env["TMP_TST_FILE_1"],
+							"testdata/fixtures/should_use_handler_env__1")
+					},
 				}
-			}
-			if !allPass {
-				testutils.Logf(t, "Conditions do not hold yet")
-			}
-			if allPass {
-				testutils.Logf(t, "All conditions hold, returning")
-				return
-			}
-			if time.Now().After(deadline) {
-				testutils.Logf(t, "After deadline, exiting")
-				return
-			}
-		case <-ctx.Done():
-			testutils.Logf(t, "waitTillHolds: Context cancelled, cause: %v", context.Cause(ctx))
-			return
-		}
-	}
+				waitTillHolds(ctx, t, funcs, 400*time.Millisecond)
+			},
+		},
+		{
+			name:        "should render job env",
+			config:      "testdata/configs/should_render_job_env.toml",
+			extraArgs:   []string{"run"},
+			expectError: true,
+			hyprEvents: []string{
+				"windowtitlev2>>558f74f82570,Mozilla Firefox",
+			},
+			validateSideEffects: func(t *testing.T, env map[string]string) {
+				compareWithFixture(t, env["TMP_TST_FILE_0"],
+					"testdata/fixtures/should_render_job_env")
+			},
+			waitForSideEffects: func(ctx context.Context, t *testing.T, env map[string]string) {
+				funcs := []func() error{
+					func() error {
+						return testutils.ContentSameAsFixture(t, env["TMP_TST_FILE_0"],
+							"testdata/fixtures/should_render_job_env")
+					},
+				}
+				waitTillHolds(ctx, t, funcs, 400*time.Millisecond)
+			},
+		},
+		{
+			name:        "should expand routing key with job env",
+			config:      "testdata/configs/should_expand_routing_key_with_job_env.toml",
+			extraArgs:   []string{"run"},
+			expectError: true,
+			hyprEvents: []string{
+				"windowtitlev2>>558f74f82570,Mozilla Firefox",
+			},
+			validateSideEffects: func(t *testing.T, env map[string]string) {
+				compareWithFixture(t, env["TMP_TST_FILE_0"],
+					"testdata/fixtures/should_expand_routing_key_with_job_env")
+			},
+			waitForSideEffects: func(ctx context.Context, t *testing.T, env map[string]string) {
+				funcs := []func() error{
+					func() error {
+						return testutils.ContentSameAsFixture(t, env["TMP_TST_FILE_0"],
+							"testdata/fixtures/should_expand_routing_key_with_job_env")
+					},
+				}
+				waitTillHolds(ctx, t, funcs, 400*time.Millisecond)
+			},
+		},
+		{
+			name:                "should fail missing env file",
+			config:              "testdata/configs/should_fail_missing_env_file.toml",
+			extraArgs:           []string{"validate"},
+			expectError:         true,
+			expectErrorContains: "env_file is invalid",
+		},
+		{
+			name:        "should use session env file",
+			config:      "testdata/configs/should_use_session_env_file.toml",
+			extraArgs:   []string{"run"},
+			expectError: true,
+			hyprEvents: []string{
+				"windowtitlev2>>558f74f82570,Mozilla Firefox",
+			},
+			validateSideEffects: func(t *testing.T, env map[string]string) {
+				compareWithFixture(t, env["TMP_TST_FILE_0"],
+					"testdata/fixtures/should_use_session_env_file")
+			},
+			waitForSideEffects: func(ctx context.Context, t *testing.T, env map[string]string) {
+				funcs := []func() error{
+					func() error {
+						return testutils.ContentSameAsFixture(t, env["TMP_TST_FILE_0"],
+							"testdata/fixtures/should_use_session_env_file")
+					},
+				}
+				waitTillHolds(ctx, t, funcs, 400*time.Millisecond)
+			},
+		},
+		{
+			name:        "should use hyprland session env",
+			config:      "testdata/configs/should_use_hyprland_session_env.toml",
+			extraArgs:   []string{"run"},
+			expectError: true,
+			hyprEvents: []string{
+				"windowtitlev2>>558f74f82570,Mozilla Firefox",
+			},
+			prepareRuntimeDir: func(t *testing.T, xdgRuntimeDir, signature string) {
+				testutils.SetupHyprLock(t, xdgRuntimeDir, signature, os.Getpid(), "wayland-test")
+			},
+			validateSideEffects: func(t *testing.T, env map[string]string) {
+				compareWithFixture(t, env["TMP_TST_FILE_0"],
+					"testdata/fixtures/should_use_hyprland_session_env")
+			},
+			waitForSideEffects: func(ctx context.Context, t *testing.T, env map[string]string) {
+				funcs := []func() error{
+					func() error {
+						return testutils.ContentSameAsFixture(t, env["TMP_TST_FILE_0"],
+							"testdata/fixtures/should_use_hyprland_session_env")
+					},
+				}
+				waitTillHolds(ctx, t, funcs, 400*time.Millisecond)
+			},
+		},
+		{
+			name:        "should follow hyprland restart",
+			config:      "testdata/configs/should_follow_hyprland_restart.toml",
+			extraArgs:   []string{"run"},
+			expectError: true,
+			hyprEvents: []string{
+				"windowtitlev2>>558f74f82570,restart",
+				"windowtitlev2>>558f74f82570,after",
+			},
+			prepareRuntimeDir: func(t *testing.T, xdgRuntimeDir, signature string) {
+				testutils.SetupHyprLock(t, xdgRuntimeDir, signature, os.Getpid(), "wayland-test")
+			},
+			validateSideEffects: func(t *testing.T, env map[string]string) {
+				compareWithFixture(t, env["TMP_TST_FILE_0"],
+					"testdata/fixtures/should_follow_hyprland_restart")
+			},
+			waitForSideEffects: func(ctx context.Context, t *testing.T, env map[string]string) {
+				funcs := []func() error{
+					func() error {
+						return testutils.ContentSameAsFixture(t, env["TMP_TST_FILE_0"],
+							"testdata/fixtures/should_follow_hyprland_restart")
+					},
+				}
+				waitTillHolds(ctx, t, funcs, 400*time.Millisecond)
+			},
+		},
+		{
+			name:        "should dispatch to session",
+			config:      "testdata/configs/should_dispatch_to_session.toml",
+			extraArgs:   []string{"run"},
+			expectError: true,
+			hyprEvents: []string{
+				"windowtitlev2>>558f74f82570,Mozilla Firefox",
+			},
+			prepareRuntimeDir: func(t *testing.T, xdgRuntimeDir, _ string) {
+				require.NoError(t, os.MkdirAll(filepath.Join(xdgRuntimeDir, "hypr", "restarted"), 0o750))
+				testutils.SetupHyprLock(t, xdgRuntimeDir, "restarted", os.Getpid(), "wayland-restarted")
+				listener, teardown := testutils.SetupHyprSocket(context.Background(), t,
+					xdgRuntimeDir, "restarted", hypr.GetHyprCommandSocket)
+				t.Cleanup(teardown)
+				testutils.SetupFakeHyprCommandServer(t, listener)
+			},
+			validateSideEffects: func(t *testing.T, env map[string]string) {
+				compareWithFixture(t, env["TMP_TST_FILE_0"],
+					"testdata/fixtures/should_dispatch_to_session")
+			},
+			waitForSideEffects: func(ctx context.Context, t *testing.T, env map[string]string) {
+				waitTillHolds(ctx, t, []func() error{
+					func() error {
+						return testutils.ContentSameAsFixture(t, env["TMP_TST_FILE_0"],
+							"testdata/fixtures/should_dispatch_to_session")
+					},
+				}, 400*time.Millisecond)
+			},
+		},
+		{
+			name:                "should fail missing session env file",
+			config:              "testdata/configs/should_fail_missing_session_env_file.toml",
+			extraArgs:           []string{"validate"},
+			expectError:         true,
+			expectErrorContains: "session_env_file has to be set",
+		},
+		{
+			name:        "should expose event metadata",
+			config:      "testdata/configs/should_expose_event_metadata.toml",
+			extraArgs:   []string{"run"},
+			expectError: true,
+			hyprEvents: []string{
+				"windowtitlev2>>558f74f82570,Mozilla Firefox",
+			},
+			validateSideEffects: func(t *testing.T, env map[string]string) {
+				compareWithFixture(t, env["TMP_TST_FILE_0"],
+					"testdata/fixtures/should_expose_event_metadata__0")
+				compareWithFixture(t, env["TMP_TST_FILE_1"],
+					"testdata/fixtures/should_expose_event_metadata__1")
+			},
+			waitForSideEffects: func(ctx context.Context, t *testing.T, env map[string]string) {
+				funcs := []func() error{
+					func() error {
+						return testutils.ContentSameAsFixture(t, env["TMP_TST_FILE_0"],
+							"testdata/fixtures/should_expose_event_metadata__0")
+					},
+					func() error {
+						return testutils.ContentSameAsFixture(t, env["TMP_TST_FILE_1"],
+							"testdata/fixtures/should_expose_event_metadata__1")
+					},
+				}
+				waitTillHolds(ctx, t, funcs, 400*time.Millisecond)
+			},
+		},
+		{
+			name:        "should render templates",
+			config:      "testdata/configs/should_render_templates.toml",
+			extraArgs:   []string{"run"},
+			expectError: true,
+			hyprEvents: []string{
+				"windowtitlev2>>558f74f82570,Mozilla Firefox",
+			},
+			validateSideEffects: func(t *testing.T, env map[string]string) {
+				compareWithFixture(t, env["TMP_TST_FILE_0"],
+					"testdata/fixtures/should_render_templates")
+			},
+			waitForSideEffects: func(ctx context.Context, t *testing.T, env map[string]string) {
+				funcs := []func() error{
+					func() error {
+						return testutils.ContentSameAsFixture(t, env["TMP_TST_FILE_0"],
+							"testdata/fixtures/should_render_templates")
+					},
+				}
+				waitTillHolds(ctx, t, funcs, 400*time.Millisecond)
+			},
+			expectLogsContain: []string{
+				"routing_key=\"558f74f82570\"",
+			},
+		},
+		{
+			name:        "should evaluate condition",
+			config:      "testdata/configs/should_evaluate_condition.toml",
+			extraArgs:   []string{"run"},
+			expectError: true,
+			hyprEvents: []string{
+				"workspacev2>>9,nine",
+				"workspacev2>>3,three",
+				"workspacev2>>7,seven",
+			},
+			validateSideEffects: func(t *testing.T, env map[string]string) {
+				compareWithFixture(t, env["TMP_TST_FILE_0"],
+					"testdata/fixtures/should_evaluate_condition")
+			},
+			waitForSideEffects: func(ctx context.Context, t *testing.T, env map[string]string) {
+				funcs := []func() error{
+					func() error {
+						return testutils.ContentSameAsFixture(t, env["TMP_TST_FILE_0"],
+							"testdata/fixtures/should_evaluate_condition")
+					},
+				}
+				waitTillHolds(ctx, t, funcs, 400*time.Millisecond)
+			},
+		},
+		{
+			name:        "should evaluate condition state",
+			config:      "testdata/configs/should_evaluate_condition_state.toml",
+			extraArgs:   []string{"run"},
+			expectError: true,
+			hyprEvents: []string{
+				"workspacev2>>9,nine",
+				"workspacev2>>3,three",
+				"workspacev2>>7,seven",
+			},
+			validateSideEffects: func(t *testing.T, env map[string]string) {
+				compareWithFixture(t, env["TMP_TST_FILE_0"],
+					"testdata/fixtures/should_evaluate_condition_state")
+			},
+			waitForSideEffects: func(ctx context.Context, t *testing.T, env map[string]string) {
+				funcs := []func() error{
+					func() error {
+						return testutils.ContentSameAsFixture(t, env["TMP_TST_FILE_0"],
+							"testdata/fixtures/should_evaluate_condition_state")
+					},
+				}
+				waitTillHolds(ctx, t, funcs, 400*time.Millisecond)
+			},
+		},
+		{
+			name:                "should fail invalid condition",
+			config:              "testdata/configs/should_fail_invalid_condition.toml",
+			extraArgs:           []string{"validate"},
+			expectError:         true,
+			expectErrorContains: "mismatched types string and int",
+		},
+		{
+			name:        "should guard with check",
+			config:      "testdata/configs/should_guard_with_check.toml",
+			extraArgs:   []string{"run"},
+			expectError: true,
+			hyprEvents: []string{
+				"windowtitlev2>>558f74f82570,skip",
+				"windowtitlev2>>558f74f82570,go",
+			},
+			validateSideEffects: func(t *testing.T, env map[string]string) {
+				compareWithFixture(t, env["TMP_TST_FILE_0"],
+					"testdata/fixtures/should_guard_with_check__0")
+				compareWithFixture(t, env["TMP_TST_FILE_1"],
+					"testdata/fixtures/should_guard_with_check__1")
+			},
+			waitForSideEffects: func(ctx context.Context, t *testing.T, env map[string]string) {
+				funcs := []func() error{
+					func() error {
+						return testutils.ContentSameAsFixture(t, env["TMP_TST_FILE_0"],
+							"testdata/fixtures/should_guard_with_check__0")
+					},
+					func() error {
+						return testutils.ContentSameAsFixture(t, env["TMP_TST_FILE_1"],
+							"testdata/fixtures/should_guard_with_check__1")
+					},
+				}
+				waitTillHolds(ctx, t, funcs, 400*time.Millisecond)
+			},
+			expectLogsContain: []string{
+				"skipped=\"true\"",
+			},
+		},
+		{
+			name:        "should cache check per handler",
+			config:      "testdata/configs/should_cache_check_per_handler.toml",
+			extraArgs:   []string{"run", "--workers", "1"},
+			expectError: true,
+			hyprEvents: []string{
+				"windowtitlev2>>558f74f82570,first",
+				"windowtitlev2>>558f74f82570,second",
+			},
+			validateSideEffects: func(t *testing.T, env map[string]string) {
+				compareWithFixture(t, env["TMP_TST_FILE_0"],
+					"testdata/fixtures/should_cache_check_per_handler")
+			},
+			waitForSideEffects: func(ctx context.Context, t *testing.T, env map[string]string) {
+				funcs := []func() error{
+					func() error {
+						return testutils.ContentSameAsFixture(t, env["TMP_TST_FILE_0"],
+							"testdata/fixtures/should_cache_check_per_handler")
+					},
+				}
+				waitTillHolds(ctx, t, funcs, 400*time.Millisecond)
+			},
+		},
+		{
+			name:                "should fail check_timeout without check",
+			config:              "testdata/configs/should_fail_check_timeout_without_check.toml",
+			extraArgs:           []string{"validate"},
+			expectError:         true,
+			expectErrorContains: "check_timeout and check_cache_ttl require check",
+		},
+		{
+			name:        "should run steps",
+			config:      "testdata/configs/should_run_steps.toml",
+			extraArgs:   []string{"run"},
+			expectError: true,
+			hyprEvents: []string{
+				"windowtitlev2>>558f74f82570,Mozilla Firefox",
+			},
+			validateSideEffects: func(t *testing.T, env map[string]string) {
+				compareWithFixture(t, env["TMP_TST_FILE_0"],
+					"testdata/fixtures/should_run_steps")
+			},
+			waitForSideEffects: func(ctx context.Context, t *testing.T, env map[string]string) {
+				funcs := []func() error{
+					func() error {
+						return testutils.ContentSameAsFixture(t, env["TMP_TST_FILE_0"],
+							"testdata/fixtures/should_run_steps")
+					},
+				}
+				waitTillHolds(ctx, t, funcs, 400*time.Millisecond)
+			},
+			expectLogsContain: []string{
+				"Step failed, continuing",
+				"context deadline exceeded",
+			},
+		},
+		{
+			name:                "should fail invalid step",
+			config:              "testdata/configs/should_fail_invalid_step.toml",
+			extraArgs:           []string{"validate"},
+			expectError:         true,
+			expectErrorContains: "step 0 is invalid: on_failure has to be one of",
+		},
+		{
+			name:        "should use handler names",
+			config:      "testdata/configs/should_use_handler_names.toml",
+			extraArgs:   []string{"run"},
+			expectError: true,
+			hyprEvents: []string{
+				"windowtitlev2>>558f74f82570,Mozilla Firefox",
+			},
+			validateSideEffects: func(t *testing.T, env map[string]string) {
+				compareWithFixture(t, env["TMP_TST_FILE_0"],
+					"testdata/fixtures/should_use_handler_names")
+			},
+			waitForSideEffects: func(ctx context.Context, t *testing.T, env map[string]string) {
+				funcs := []func() error{
+					func() error {
+						return testutils.ContentSameAsFixture(t, env["TMP_TST_FILE_0"],
+							"testdata/fixtures/should_use_handler_names")
+					},
+				}
+				waitTillHolds(ctx, t, funcs, 400*time.Millisecond)
+			},
+			expectLogsContain: []string{
+				"handler=\"firefox-title\"",
+			},
+		},
+		{
+			name:      "should list handlers",
+			config:    "testdata/configs/should_use_handler_names.toml",
+			extraArgs: []string{"list"},
+			expectLogsContain: []string{
+				"windowtitlev2\n  firefox-title  (.*),Mozilla (.*)  browser,audit  Records Firefox window titles\n",
+				"workspacev2\n  handler-1  (.*),(.*)",
+			},
+		},
+		{
+			name:                "should fail duplicate handler names",
+			config:              "testdata/configs/should_fail_duplicate_handler_names.toml",
+			extraArgs:           []string{"validate"},
+			expectError:         true,
+			expectErrorContains: "event 1 (firefox) validation failed: name is already used by event 0",
+		},
+		{
+			name:        "should expand templates",
+			config:      "testdata/configs/should_expand_templates.toml",
+			extraArgs:   []string{"run"},
+			expectError: true,
+			hyprEvents: []string{
+				"windowtitlev2>>558f74f82570,Mozilla Firefox",
+				"workspacev2>>1,1",
+			},
+			validateSideEffects: func(t *testing.T, env map[string]string) {
+				compareWithFixture(t, env["TMP_TST_FILE_0"],
+					"testdata/fixtures/should_expand_templates")
+			},
+			waitForSideEffects: func(ctx context.Context, t *testing.T, env map[string]string) {
+				funcs := []func() error{
+					func() error {
+						return testutils.ContentSameAsFixture(t, env["TMP_TST_FILE_0"],
+							"testdata/fixtures/should_expand_templates")
+					},
+				}
+				waitTillHolds(ctx, t, funcs, 400*time.Millisecond)
+			},
+		},
+		{
+			name:        "should override template action",
+			config:      "testdata/configs/should_override_template_action.toml",
+			extraArgs:   []string{"run"},
+			expectError: true,
+			hyprEvents: []string{
+				"windowtitlev2>>558f74f82570,Mozilla Firefox",
+			},
+			validateSideEffects: func(t *testing.T, env map[string]string) {
+				compareWithFixture(t, env["TMP_TST_FILE_0"],
+					"testdata/fixtures/should_override_template_action")
+			},
+			waitForSideEffects: func(ctx context.Context, t *testing.T, env map[string]string) {
+				funcs := []func() error{
+					func() error {
+						return testutils.ContentSameAsFixture(t, env["TMP_TST_FILE_0"],
+							"testdata/fixtures/should_override_template_action")
+					},
+				}
+				waitTillHolds(ctx, t, funcs, 400*time.Millisecond)
+			},
+		},
+		{
+			name:      "should print expanded handlers",
+			config:    "testdata/configs/should_expand_templates.toml",
+			extraArgs: []string{"validate", "--print"},
+			expectLogsContain: []string{
+				"[[handler]]\nname = \"firefox\"\non = \"windowtitlev2\"\nwhen = \"(.*),Mozilla (.*)\"\n" +
+					"then = \"echo $HWT_HANDLER_NAME $PREFIX $REGEX_GROUP_2 $SUFFIX >> $TMP_TST_FILE_0\"\n" +
+					"routing_key = \"$REGEX_GROUP_1\"\n",
+				"[handler.env]\nPREFIX = \"title\"\nSUFFIX = \"from-handler\"\n",
+			},
+		},
+		{
+			name:        "should run yaml config",
+			config:      "testdata/configs/should_run_yaml_config.yaml",
+			extraArgs:   []string{"run"},
+			expectError: true,
+			hyprEvents: []string{
+				"windowtitlev2>>558f74f82570,Mozilla Firefox",
+			},
+			validateSideEffects: func(t *testing.T, env map[string]string) {
+				compareWithFixture(t, env["TMP_TST_FILE_0"],
+					"testdata/fixtures/should_run_yaml_config")
+			},
+			waitForSideEffects: func(ctx context.Context, t *testing.T, env map[string]string) {
+				funcs := []func() error{
+					func() error {
+						return testutils.ContentSameAsFixture(t, env["TMP_TST_FILE_0"],
+							"testdata/fixtures/should_run_yaml_config")
+					},
+				}
+				waitTillHolds(ctx, t, funcs, 400*time.Millisecond)
+			},
+		},
+		{
+			name:        "should run json config",
+			config:      "testdata/configs/should_run_json_config.json",
+			extraArgs:   []string{"run"},
+			expectError: true,
+			hyprEvents: []string{
+				"windowtitlev2>>558f74f82570,Mozilla Firefox",
+			},
+			validateSideEffects: func(t *testing.T, env map[string]string) {
+				compareWithFixture(t, env["TMP_TST_FILE_0"],
+					"testdata/fixtures/should_run_json_config")
+			},
+			waitForSideEffects: func(ctx context.Context, t *testing.T, env map[string]string) {
+				funcs := []func() error{
+					func() error {
+						return testutils.ContentSameAsFixture(t, env["TMP_TST_FILE_0"],
+							"testdata/fixtures/should_run_json_config")
+					},
+				}
+				waitTillHolds(ctx, t, funcs, 400*time.Millisecond)
+			},
+		},
+		{
+			name:              "should detect config format",
+			config:            "testdata/configs/should_run_yaml_config.yaml",
+			extraArgs:         []string{"validate"},
+			expectLogsContain: []string{`msg="Configuration is valid" format="yaml"`},
+		},
+		{
+			name:                "should fail invalid yaml handler",
+			config:              "testdata/configs/should_fail_invalid_yaml_handler.yaml",
+			extraArgs:           []string{"validate"},
+			expectError:         true,
+			expectErrorContains: `msg="event 1 validation failed: retries must be >= 0"`,
+			expectLogsContain:   []string{`should_fail_invalid_yaml_handler.yaml:9:5"`},
+		},
+		{
+			name:                "should fail yaml type error",
+			config:              "testdata/configs/should_fail_yaml_type_error.yaml",
+			extraArgs:           []string{"validate"},
+			expectError:         true,
+			expectErrorContains: "handler.retries: incompatible types: value has type string; destination has type integer",
+			expectLogsContain:   []string{`should_fail_yaml_type_error.yaml:12:5"`},
+		},
+		{
+			name:   "should convert config",
+			config: "testdata/configs/should_run_yaml_config.yaml",
+			extraArgs: []string{
+				"config", "convert", "testdata/configs/should_run_yaml_config.yaml", "--to", "toml",
+			},
+			expectLogsContain: []string{
+				"[[handler]]\n" +
+					"name = \"firefox\"\n" +
+					"on = \"windowtitlev2\"\n" +
+					"then = \"echo \\\"title $REGEX_GROUP_2\\\" >> $TMP_TST_FILE_0\\necho \\\"window $REGEX_GROUP_1\\\" >> $TMP_TST_FILE_0\\n\"\n" +
+					"when = \"${var.firefox}\"\n" +
+					"[handler.env]\n" +
+					"UNUSED = \"1\"\n",
+				"[vars]\nfirefox = \"(.*),Mozilla (.*)\"\n",
+			},
+		},
+		{
+			name:                "should fail undefined profile",
+			config:              "testdata/configs/should_fail_undefined_profile.toml",
+			extraArgs:           []string{"validate"},
+			expectError:         true,
+			expectErrorContains: `event 0 validation failed: profile \"laptop\" is not defined`,
+		},
+		{
+			name:                "should fail undefined var",
+			config:              "testdata/configs/should_fail_undefined_var.toml",
+			extraArgs:           []string{"validate"},
+			expectError:         true,
+			expectErrorContains: `variable \"missing\" is not defined`,
+		},
+		{
+			name:        "should load includes",
+			config:      "testdata/configs/should_load_includes.toml",
+			extraArgs:   []string{"run"},
+			expectError: true,
+			hyprEvents: []string{
+				"windowtitlev2>>558f74f82570,Mozilla Firefox",
+			},
+			validateSideEffects: func(t *testing.T, env map[string]string) {
+				compareWithFixture(t, env["TMP_TST_FILE_0"],
+					"testdata/fixtures/should_load_includes")
+			},
+			waitForSideEffects: func(ctx context.Context, t *testing.T, env map[string]string) {
+				funcs := []func() error{
+					func() error {
+						return testutils.ContentSameAsFixture(t, env["TMP_TST_FILE_0"],
+							"testdata/fixtures/should_load_includes")
+					},
+				}
+				waitTillHolds(ctx, t, funcs, 400*time.Millisecond)
+			},
+		},
+		{
+			name:                "should fail invalid include",
+			config:              "testdata/configs/should_fail_invalid_include.toml",
+			extraArgs:           []string{"validate"},
+			expectError:         true,
+			expectErrorContains: `msg="event 1 validation failed: 'when' field is required"`,
+			expectLogsContain:   []string{`includes/invalid.toml:3:1"`},
+		},
+		{
+			name:                "should report all errors",
+			config:              "testdata/configs/should_fail_multiple_errors.toml",
+			extraArgs:           []string{"validate"},
+			expectError:         true,
+			expectErrorContains: `msg="Configuration is invalid" errors="3" warnings="1"`,
+			expectLogsContain: []string{
+				`msg="event 0 validation failed: 'when' field is required"`,
+				`should_fail_multiple_errors.toml:5:1"`,
+				`msg="event 1 validation failed: retries must be >= 0"`,
+				`should_fail_multiple_errors.toml:9:1"`,
+				`msg="general section validation failed: workers must be positive"`,
+				`should_fail_multiple_errors.toml:1:1"`,
+				`msg="unknown key \"handler.tmeout\""`,
+				`should_fail_multiple_errors.toml:14:1"`,
+			},
+		},
+		{
+			name:                "should report errors as json",
+			config:              "testdata/configs/should_fail_multiple_errors.toml",
+			extraArgs:           []string{"validate", "--format", "json"},
+			expectError:         true,
+			expectErrorContains: `"valid": false`,
+			expectLogsContain: []string{
+				"\"line\": 9,\n      \"column\": 1,\n      \"message\": \"event 1 validation failed: retries must be >= 0\"",
+			},
+		},
+		{
+			name:                "should fail strict checks",
+			config:              "testdata/configs/should_fail_strict.toml",
+			extraArgs:           []string{"validate", "--strict"},
+			expectError:         true,
+			expectErrorContains: `msg="Configuration is invalid" errors="2" warnings="2"`,
+			expectLogsContain: []string{
+				"msg=\"event 0: then has a syntax error: line 1: unexpected EOF while looking for matching `\\\"'\"",
+				`msg="event 1: then runs hwt-missing-program which is not found on PATH"`,
+				`msg="event 2: then uses $REGEX_GROUP_2 but the regex has 1 capture groups"`,
+				`msg="event 3: then expands $REGEX_GROUP_1 without quotes, use \"$REGEX_GROUP_1\""`,
+			},
+		},
+		{
+			name:      "should pass strict checks",
+			config:    "testdata/configs/should_pass_strict.toml",
+			extraArgs: []string{"validate", "--strict"},
+			expectLogsContain: []string{
+				`msg="event 3: then expands $REGEX_GROUP_1 without quotes, use \"$REGEX_GROUP_1\""`,
+				`msg="Configuration is valid"`,
+			},
+		},
+		{
+			name:      "should lint config",
+			config:    "testdata/configs/should_lint_config.toml",
+			extraArgs: []string{"validate", "--format", "json"},
+			expectLogsContain: []string{
+				`"valid": true`,
+				"\"line\": 5,\n      \"column\": 1,\n      \"message\": \"unknown key \\\"general.debounce\\\"\"",
+				"\"line\": 7,\n      \"column\": 1,\n      \"message\": \"event 0: \\\"windowtitle2\\\" is not a known Hyprland event\"",
+				"\"line\": 12,\n      \"column\": 1,\n      \"message\": \"event 1: routing_key uses capture group 2 but the regex has 1\"",
+				"\"line\": 18,\n      \"column\": 1,\n      \"message\": \"event 2: regex can never match openwindow events, " +
+					"their data is WINDOWADDRESS,WORKSPACENAME,WINDOWCLASS,WINDOWTITLE\"",
+				"\"line\": 23,\n      \"column\": 1,\n      \"message\": \"event 3 (copy): duplicates event 1\"",
+			},
+		},
+		{
+			name:   "should simulate events",
+			config: "testdata/configs/should_simulate_events.toml",
+			extraArgs: []string{
+				"simulate", "windowtitlev2>>558f74f82570,Mozilla Firefox", "activewindowv2>>558f74f82570",
+				"--file", "testdata/events/should_simulate_events.txt",
+			},
+			expectLogsContain: []string{
+				"firefox (",
+				"should_simulate_events.toml:7:1): runs\n" +
+					"    captures:    [\"558f74f82570,Mozilla Firefox\" \"558f74f82570\"]\n" +
+					"    exec:        echo $REGEX_GROUP_1 >> $TMP_TST_FILE_0\n" +
+					"    routing key: 558f74f82570\n" +
+					"    worker:      2\n" +
+					"    env:\n" +
+					"      BROWSER=firefox\n",
+				"      HWT_ROUTING_KEY=558f74f82570\n",
+				"should_simulate_events.toml:15:1): skipped, profile focus is not active",
+				"activewindowv2>>558f74f82570\n  no handlers react to the event type",
+				"workspacev2>>3,three\n  high-workspace (",
+				"should_simulate_events.toml:22:1): skipped, condition is false",
+				"workspacev2>>7,seven\n  high-workspace (",
+				"    captures:    [\"7,seven\" \"7\" \"seven\"]",
+				"(job id)\n",
+			},
+			validateSideEffects: func(t *testing.T, env map[string]string) {
+				assert.NoFileExists(t, env["TMP_TST_FILE_0"], "simulate must not run the handlers")
+			},
+		},
+		{
+			name:                "should fail simulating invalid event",
+			config:              "testdata/configs/should_simulate_events.toml",
+			extraArgs:           []string{"simulate", "windowtitlev2"},
+			expectError:         true,
+			expectErrorContains: `event \"windowtitlev2\" is invalid, expected TYPE>>DATA`,
+		},
+		{
+			name:        "should keep config on invalid reload",
+			config:      "testdata/configs/should_keep_config_on_invalid_reload.toml",
+			extraArgs:   []string{"run"},
+			expectError: true,
+			copyConfig:  true,
+			hyprEvents: []string{
+				"windowtitlev2>>558f74f82570,Mozilla Firefox",
+			},
+			validateSideEffects: func(t *testing.T, env map[string]string) {
+				compareWithFixture(t, env["TMP_TST_FILE_1"],
+					"testdata/fixtures/should_keep_config_on_invalid_reload__1")
+			},
+			waitForSideEffects: func(ctx context.Context, t *testing.T, env map[string]string) {
+				waitTillHolds(ctx, t, []func() error{
+					func() error {
+						return testutils.ContentSameAsFixture(t, env["TMP_TST_FILE_0"],
+							"testdata/fixtures/should_keep_config_on_invalid_reload__0")
+					},
+				}, 400*time.Millisecond)
+				require.NoError(t, os.WriteFile(env["HWT_TEST_CONFIG"], []byte("[general]\ntimeout = \"-1s\"\n"), 0o600))
+				waitTillHolds(ctx, t, []func() error{
+					func() error {
+						return testutils.ContentSameAsFixture(t, env["TMP_TST_FILE_1"],
+							"testdata/fixtures/should_keep_config_on_invalid_reload__1")
+					},
+				}, 400*time.Millisecond)
+			},
+			expectLogsContain: []string{
+				"Config reload failed, keeping the previous configuration",
+			},
+		},
+		{
+			name:        "should cancel stale jobs",
+			config:      "testdata/configs/should_cancel_stale_jobs.toml",
+			extraArgs:   []string{"run"},
+			expectError: true,
+			copyConfig:  true,
+			hyprEvents: []string{
+				"windowtitlev2>>558f74f82570,first",
+				"windowtitlev2>>558f74f82570,second",
+			},
+			waitForSideEffects: func(ctx context.Context, t *testing.T, env map[string]string) {
+				waitTillHolds(ctx, t, []func() error{
+					func() error {
+						return testutils.ContentSameAsFixture(t, env["TMP_TST_FILE_0"],
+							"testdata/fixtures/should_cancel_stale_jobs__0")
+					},
+				}, 400*time.Millisecond)
+				// The drop job of the first event is queued behind keep, remove its handler.
+				require.NoError(t, os.WriteFile(env["HWT_TEST_CONFIG"], []byte(`[general]
+timeout = "1s"
+hot_reload_debounce_timer = "10ms"
+
+[[handler]]
+name = "keep"
+on = "windowtitlev2"
+when = "(.*),(.*)"
+then = "echo keep $REGEX_GROUP_2 >> $TMP_TST_FILE_0; sleep 0.3"
+routing_key = "same"
+on_reload = "cancel"
+`), 0o600))
+				waitTillHolds(ctx, t, []func() error{
+					func() error {
+						return testutils.ContentSameAsFixture(t, env["TMP_TST_FILE_0"],
+							"testdata/fixtures/should_cancel_stale_jobs__1")
+					},
+				}, 800*time.Millisecond)
+			},
+			expectLogsContain: []string{
+				`msg="Config reloaded" added="" generation="2" modified=""`,
+				`removed="drop"`,
+				`Handler was removed or changed by a reload, dropping the queued job`,
+			},
+		},
+		{
+			name:        "should detect env file changes",
+			config:      "testdata/configs/should_detect_env_file_changes.toml",
+			extraArgs:   []string{"run"},
+			expectError: true,
+			copyConfig:  true,
+			hyprEvents: []string{
+				"windowtitlev2>>558f74f82570,Mozilla Firefox",
+			},
+			prepareRuntimeDir: func(t *testing.T, xdgRuntimeDir, _ string) {
+				require.NoError(t, os.WriteFile(filepath.Join(xdgRuntimeDir, "greeter.env"), []byte("GREETING=hello\n"), 0o600))
+			},
+			waitForSideEffects: func(ctx context.Context, t *testing.T, env map[string]string) {
+				waitTillHolds(ctx, t, []func() error{
+					func() error {
+						return testutils.ContentSameAsFixture(t, env["TMP_TST_FILE_0"],
+							"testdata/fixtures/should_detect_env_file_changes")
+					},
+				}, 400*time.Millisecond)
+				// Only the env file changes, its directory is watched so that
+				// alone triggers the reload.
+				envFile := filepath.Join(os.Getenv("XDG_RUNTIME_DIR"), "greeter.env")
+				require.NoError(t, os.WriteFile(envFile, []byte("GREETING=bye\n"), 0o600))
+			},
+			waitForLogs: []string{
+				`modified="greeter"`,
+			},
+			expectLogsContain: []string{
+				`msg="Config reloaded" added="" generation="2" modified="greeter"`,
+			},
+		},
+		{
+			name:        "should skip unchanged reload",
+			config:      "testdata/configs/should_skip_unchanged_reload.toml",
+			extraArgs:   []string{"run"},
+			expectError: true,
+			copyConfig:  true,
+			hyprEvents: []string{
+				"windowtitlev2>>558f74f82570,Mozilla Firefox",
+			},
+			waitForSideEffects: func(ctx context.Context, t *testing.T, env map[string]string) {
+				waitTillHolds(ctx, t, []func() error{
+					func() error {
+						return testutils.ContentSameAsFixture(t, env["TMP_TST_FILE_0"],
+							"testdata/fixtures/should_skip_unchanged_reload")
+					},
+				}, 400*time.Millisecond)
+				// An unrelated file in the config directory is ignored, give a
+				// reload it would trigger a few debounce periods to show up.
+				dir := filepath.Dir(env["HWT_TEST_CONFIG"])
+				require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("notes\n"), 0o600))
+				select {
+				case <-ctx.Done():
+				case <-time.After(100 * time.Millisecond):
+				}
+				// The config written with the same contents reloads without
+				// notifying the listeners.
+				// nolint:gosec
+				contents, err := os.ReadFile(env["HWT_TEST_CONFIG"])
+				require.NoError(t, err)
+				require.NoError(t, os.WriteFile(env["HWT_TEST_CONFIG"], contents, 0o600))
+			},
+			waitForLogs: []string{
+				"Config did not change, skipping reload listeners",
+			},
+			expectLogsContain: []string{
+				`msg="Config reloaded" added="" generation="2" modified=""`,
+			},
+			expectLogsNotContain: []string{
+				`generation="3"`,
+			},
+		},
+		{
+			name:        "should requeue jobs on resize",
+			config:      "testdata/configs/should_requeue_jobs_on_resize.toml",
+			extraArgs:   []string{"run"},
+			expectError: true,
+			copyConfig:  true,
+			hyprEvents: []string{
+				"windowtitlev2>>558f74f82570,first",
+				"windowtitlev2>>558f74f82570,second",
+			},
+			waitForSideEffects: func(ctx context.Context, t *testing.T, env map[string]string) {
+				waitTillHolds(ctx, t, []func() error{
+					func() error {
+						return testutils.ContentSameAsFixture(t, env["TMP_TST_FILE_0"],
+							"testdata/fixtures/should_requeue_jobs_on_resize__0")
+					},
+				}, 400*time.Millisecond)
+				// The second job is queued behind the first one that is still running.
+				// nolint:gosec
+				contents, err := os.ReadFile(env["HWT_TEST_CONFIG"])
+				require.NoError(t, err)
+				require.NoError(t, os.WriteFile(env["HWT_TEST_CONFIG"],
+					bytes.Replace(contents, []byte("[general]\n"), []byte("[general]\nworkers = 3\n"), 1), 0o600))
+				waitTillHolds(ctx, t, []func() error{
+					func() error {
+						return testutils.ContentSameAsFixture(t, env["TMP_TST_FILE_0"],
+							"testdata/fixtures/should_requeue_jobs_on_resize__1")
+					},
+				}, 600*time.Millisecond)
+			},
+			expectLogsContain: []string{
+				`msg="Worker pool resized" previous_queue_size="10" previous_workers="2" queue_size="10" requeued="1" workers="3"`,
+			},
+		},
+		{
+			name:        "should dry run",
+			config:      "testdata/configs/should_dry_run.toml",
+			extraArgs:   []string{"run", "--dry-run"},
+			expectError: true,
+			hyprEvents: []string{
+				"windowtitlev2>>558f74f82570,Mozilla Firefox",
+			},
+			waitForSideEffects: func(ctx context.Context, t *testing.T, env map[string]string) {
+				waitTillHolds(ctx, t, []func() error{
+					func() error { return testutils.FileExists(env["TMP_TST_FILE_0"]) },
+				}, 300*time.Millisecond)
+			},
+			validateSideEffects: func(t *testing.T, env map[string]string) {
+				assert.NoFileExists(t, env["TMP_TST_FILE_0"], "dry run must not run the command")
+				assert.NoFileExists(t, env["TMP_TST_FILE_1"], "dry run must not run the check")
+			},
+			expectLogsContain: []string{
+				`msg="Dry run enabled, jobs are logged instead of executed"`,
+				`msg="Dry run, not executing the job" attempt="1" check="echo checked >> $TMP_TST_FILE_1"`,
+				`env="[HWT_ATTEMPT=1 HWT_CONFIG=`,
+				`exec="echo $REGEX_GROUP_2 >> $TMP_TST_FILE_0" handler="title"`,
+				`routing_key="558f74f82570" timeout="1s"`,
+				`msg="Worker result collected" attempts="1" dry_run="true"`,
+			},
+		},
+		{
+			name:        "should end dry run from config",
+			config:      "testdata/configs/should_dry_run.toml",
+			extraArgs:   []string{"run", "--dry-run"},
+			expectError: true,
+			copyConfig:  true,
+			hyprEvents: []string{
+				"windowtitlev2>>558f74f82570,Mozilla Firefox",
+			},
+			waitForSideEffects: func(ctx context.Context, t *testing.T, env map[string]string) {
+				// nolint:gosec
+				content, err := os.ReadFile(env["HWT_TEST_CONFIG"])
+				require.NoError(t, err)
+				// The flag wins until the config changes dry_run, from then on
+				// the config decides.
+				for _, dryRun := range []string{"true", "false"} {
+					select {
+					case <-ctx.Done():
+					case <-time.After(100 * time.Millisecond):
+					}
+					require.NoError(t, os.WriteFile(env["HWT_TEST_CONFIG"],
+						withGeneral(content, "dry_run = "+dryRun+"\n"), 0o600))
+				}
+			},
+			waitForLogs: []string{
+				`msg="Dry run disabled, jobs are executed"`,
+			},
+			expectLogsContain: []string{
+				`msg="Worker result collected" attempts="1" dry_run="true"`,
+				`msg="Dry run disabled, jobs are executed"`,
+			},
+		},
+		{
+			name:        "should switch dry run on reload",
+			config:      "testdata/configs/should_dry_run.toml",
+			extraArgs:   []string{"run"},
+			expectError: true,
+			copyConfig:  true,
+			hyprEvents: []string{
+				"windowtitlev2>>558f74f82570,Mozilla Firefox",
+			},
+			waitForSideEffects: func(ctx context.Context, t *testing.T, env map[string]string) {
+				waitTillHolds(ctx, t, []func() error{
+					func() error { return testutils.FileExists(env["TMP_TST_FILE_0"]) },
+				}, 300*time.Millisecond)
+				// nolint:gosec
+				content, err := os.ReadFile(env["HWT_TEST_CONFIG"])
+				require.NoError(t, err)
+				require.NoError(t, os.WriteFile(env["HWT_TEST_CONFIG"],
+					withGeneral(content, "dry_run = true\n"), 0o600))
+				// Nothing is executed once switched, give the reload time to be logged.
+				select {
+				case <-ctx.Done():
+				case <-time.After(200 * time.Millisecond):
+				}
+			},
+			validateSideEffects: func(t *testing.T, env map[string]string) {
+				testutils.AssertFileExists(t, env["TMP_TST_FILE_0"])
+			},
+			expectLogsContain: []string{
+				`msg="Worker result collected" attempts="1" dry_run="false"`,
+				`msg="Dry run enabled, jobs are logged instead of executed"`,
+			},
+		},
+		{
+			name:                "should fail invalid template",
+			config:              "testdata/configs/should_fail_invalid_template.toml",
+			extraArgs:           []string{"validate"},
+			expectError:         true,
+			expectErrorContains: "'then' template is invalid",
+		},
+		{
+			name:        "should feed coprocess",
+			config:      "testdata/configs/should_feed_coprocess.toml",
+			extraArgs:   []string{"run"},
+			expectError: true,
+			hyprEvents: []string{
+				"windowtitlev2>>558f74f82570,Mozilla Firefox",
+				"windowtitlev2>>558f74f82570,Mozilla Firefox -- Another",
+			},
+			validateSideEffects: func(t *testing.T, env map[string]string) {
+				compareWithFixture(t, env["TMP_TST_FILE_0"],
+					"testdata/fixtures/should_feed_coprocess")
+			},
+			waitForSideEffects: func(ctx context.Context, t *testing.T, env map[string]string) {
+				funcs := []func() error{
+					func() error {
+						return testutils.ContentSameAsFixture(t, env["TMP_TST_FILE_0"],
+							"testdata/fixtures/should_feed_coprocess")
+					},
+				}
+				waitTillHolds(ctx, t, funcs, 600*time.Millisecond)
+			},
+			expectLogsContain: []string{
+				"line=\"ack\"",
+			},
+		},
+		{
+			name:        "should restart coprocess",
+			config:      "testdata/configs/should_restart_coprocess.toml",
+			extraArgs:   []string{"run"},
+			expectError: true,
+			hyprEvents: []string{
+				"windowtitlev2>>558f74f82570,Mozilla Firefox",
+				"windowtitlev2>>558f74f82570,Mozilla Firefox -- Another",
+			},
+			validateSideEffects: func(t *testing.T, env map[string]string) {
+				compareWithFixture(t, env["TMP_TST_FILE_0"],
+					"testdata/fixtures/should_restart_coprocess")
+			},
+			waitForSideEffects: func(ctx context.Context, t *testing.T, env map[string]string) {
+				funcs := []func() error{
+					func() error {
+						return testutils.ContentSameAsFixture(t, env["TMP_TST_FILE_0"],
+							"testdata/fixtures/should_restart_coprocess")
+					},
+				}
+				waitTillHolds(ctx, t, funcs, 600*time.Millisecond)
+			},
+			expectLogsContain: []string{
+				"Coprocess exited, restarting",
+			},
+		},
+		{
+			name:        "should resend to coprocess",
+			config:      "testdata/configs/should_resend_to_coprocess.toml",
+			extraArgs:   []string{"run"},
+			expectError: true,
+			hyprEvents: []string{
+				"windowtitlev2>>558f74f82570,Mozilla Firefox",
+				"windowtitlev2>>558f74f82570,Mozilla Firefox -- Another",
+			},
+			validateSideEffects: func(t *testing.T, env map[string]string) {
+				compareWithFixture(t, env["TMP_TST_FILE_0"],
+					"testdata/fixtures/should_resend_to_coprocess")
+			},
+			waitForSideEffects: func(ctx context.Context, t *testing.T, env map[string]string) {
+				funcs := []func() error{
+					func() error {
+						return testutils.ContentSameAsFixture(t, env["TMP_TST_FILE_0"],
+							"testdata/fixtures/should_resend_to_coprocess")
+					},
+				}
+				waitTillHolds(ctx, t, funcs, 600*time.Millisecond)
+			},
+			expectLogsContain: []string{
+				"Resending the events the coprocess did not read",
+				`level="error" msg="Coprocess output"`,
+				`line="ack" stream="stdout"`,
+			},
+		},
+		{
+			name:        "should read long coprocess lines",
+			config:      "testdata/configs/should_read_long_coprocess_lines.toml",
+			extraArgs:   []string{"run"},
+			expectError: true,
+			hyprEvents: []string{
+				"windowtitlev2>>558f74f82570,Mozilla Firefox",
+			},
+			validateSideEffects: func(t *testing.T, env map[string]string) {
+				compareWithFixture(t, env["TMP_TST_FILE_0"],
+					"testdata/fixtures/should_read_long_coprocess_lines")
+			},
+			waitForSideEffects: func(ctx context.Context, t *testing.T, env map[string]string) {
+				waitTillHolds(ctx, t, []func() error{
+					func() error {
+						return testutils.ContentSameAsFixture(t, env["TMP_TST_FILE_0"],
+							"testdata/fixtures/should_read_long_coprocess_lines")
+					},
+				}, 400*time.Millisecond)
+			},
+			waitForLogs: []string{
+				`line="ack" stream="stdout"`,
+			},
+			expectLogsContain: []string{
+				`line="ack" stream="stdout"`,
+			},
+		},
+		{
+			name:                "should fail when both then and coprocess are set",
+			config:              "testdata/configs/should_fail_then_and_coprocess.toml",
+			extraArgs:           []string{"validate"},
+			expectError:         true,
+			expectErrorContains: "'then', 'steps', 'coprocess', 'script' and 'script_file' are mutually exclusive",
+		},
+		{
+			name:        "should run script",
+			config:      "testdata/configs/should_run_script.toml",
+			extraArgs:   []string{"run"},
+			expectError: true,
+			hyprEvents: []string{
+				"windowtitlev2>>558f74f82570,Mozilla Firefox",
+				"windowtitlev2>>558f74f82571,Google Chrome",
+			},
+			validateSideEffects: func(t *testing.T, env map[string]string) {
+				compareWithFixture(t, env["TMP_TST_FILE_0"],
+					"testdata/fixtures/should_run_script__0")
+				compareWithFixture(t, env["TMP_TST_FILE_1"],
+					"testdata/fixtures/should_run_script__1")
+			},
+			waitForSideEffects: func(ctx context.Context, t *testing.T, env map[string]string) {
+				funcs := []func() error{
+					func() error {
+						return testutils.ContentSameAsFixture(t, env["TMP_TST_FILE_0"],
+							"testdata/fixtures/should_run_script__0")
+					},
+					func() error {
+						return testutils.ContentSameAsFixture(t, env["TMP_TST_FILE_1"],
+							"testdata/fixtures/should_run_script__1")
+					},
+				}
+				waitTillHolds(ctx, t, funcs, 400*time.Millisecond)
+			},
+			expectLogsContain: []string{
+				"processed 1 events",
+			},
+		},
+		{
+			name:                "should fail invalid script",
+			config:              "testdata/configs/should_fail_invalid_script.toml",
+			extraArgs:           []string{"validate"},
+			expectError:         true,
+			expectErrorContains: "undefined: undefined_variable",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			binaryStartingChan := make(chan struct{})
+			ctx, cancel := context.WithTimeout(context.Background(), 1000*time.Millisecond)
+			defer cancel()
+
+			// fake hypr ipc event socket
+			var fakeHyprEventServerDone chan struct{}
+			if len(tt.hyprEvents) > 0 {
+				xdgRuntimeDir, signature := testutils.SetupHyprEnvVars(t)
+				if tt.prepareRuntimeDir != nil {
+					tt.prepareRuntimeDir(t, xdgRuntimeDir, signature)
+				}
+				eventsListener, teardownEvents := testutils.SetupHyprSocket(ctx, t,
+					xdgRuntimeDir, signature, hypr.GetHyprEventsSocket)
+				defer teardownEvents()
+				fakeHyprEventServerDone = testutils.SetupFakeHyprEventsServer(ctx, t, eventsListener, tt.hyprEvents)
+			}
+
+			tmpDir := t.TempDir()
+			extraEnv := prepTestEnv(tmpDir)
+			configPath := tt.config
+			if tt.copyConfig {
+				configPath = copyConfig(t, tt.config, tmpDir)
+				extraEnv["HWT_TEST_CONFIG"] = configPath
+			}
+
+			args := append([]string{
+				"--config", configPath,
+			}, tt.extraArgs...)
+			if *debug {
+				args = append(args, "--debug")
+			}
+
+			done := make(chan struct{})
+			var output testutils.SyncBuffer
+			var binaryErr error
+
+			go func() {
+				defer close(done)
+				cmd := prepBinaryRun(ctx, args, inlineEnv(extraEnv))
+				cmd.Stdout = &output
+				cmd.Stderr = &output
+				t.Log(cmd.Args)
+				close(binaryStartingChan)
+				binaryErr = cmd.Run()
+			}()
+
+			if tt.waitForSideEffects != nil {
+				testutils.Logf(t, "Starting waitForSideEffects")
+				tt.waitForSideEffects(ctx, t, extraEnv)
+				if len(tt.waitForLogs) > 0 {
+					waitTillHolds(ctx, t, []func() error{
+						func() error { return logsContain(output.String(), tt.waitForLogs) },
+					}, 400*time.Millisecond)
+				}
+				testutils.Logf(t, "waitForSideEffects returned, calling cancel()")
+				cancel()
+			}
+
+			waitFor(t, fakeHyprEventServerDone)
+
+			select {
+			case <-time.After(1000 * time.Millisecond):
+				assert.True(t, false, "timeout while running, out: %s", output.String())
+			case <-done:
+				out := output.String()
+				t.Log(out)
+				if tt.expectError {
+					assert.Error(t, binaryErr, "expected run to fail but it succeeded. Output: %s", out)
+					assert.Contains(t, out, tt.expectErrorContains,
+						"error message should contain expected substring. Got: %s", out)
+				} else {
+					assert.NoError(t, binaryErr, "expected to exit cleanly")
+				}
+				for _, expected := range tt.expectLogsContain {
+					assert.Contains(t, out, expected,
+						"combined logs should contain a substring")
+				}
+				for _, unexpected := range tt.expectLogsNotContain {
+					assert.NotContains(t, out, unexpected,
+						"combined logs should not contain a substring")
+				}
+				if tt.validateSideEffects != nil {
+					tt.validateSideEffects(t, extraEnv)
+				}
+			}
+		})
+	}
+}
+
+func copyConfig(t *testing.T, config, tmpDir string) string {
+	// nolint:gosec
+	content, err := os.ReadFile(config)
+	require.NoError(t, err, "cant read config")
+	dir := filepath.Join(tmpDir, "config")
+	require.NoError(t, os.MkdirAll(dir, 0o750))
+	target := filepath.Join(dir, "config.toml")
+	require.NoError(t, os.WriteFile(target, content, 0o600))
+	return target
+}
+
+func inlineEnv(env map[string]string) []string {
+	extraEnv := []string{}
+	for key, value := range env {
+		extraEnv = append(extraEnv, fmt.Sprintf("%s=%s", key, value))
+	}
+	return extraEnv
+}
+
+func prepTestEnv(tmpDir string) map[string]string {
+	extraEnv := map[string]string{}
+	for i := range 10 {
+		file := filepath.Join(tmpDir, fmt.Sprintf("file_%d", i))
+		extraEnv[fmt.Sprintf("TMP_TST_FILE_%d", i)] = file
+	}
+	extraEnv["XDG_STATE_HOME"] = filepath.Join(tmpDir, "state")
+	return extraEnv
+}
+
+func waitFor(t *testing.T, server chan struct{}) {
+	if server == nil {
+		return
+	}
+
+	select {
+	case <-server:
+	case <-time.After(800 * time.Millisecond):
+		assert.True(t, false, "Fake server didn't finish in time")
+	}
+}
+
+func waitTillHolds(ctx context.Context, t *testing.T, funcs []func() error, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	testutils.Logf(t, "waitTillHolds starting, deadline in %v", timeout)
+
+	for {
+		select {
+		case <-ticker.C:
+			allPass := true
+			for _, f := range funcs {
+				if err := f(); err != nil {
+					allPass = false
+					break
+				}
+			}
+			if !allPass {
+				testutils.Logf(t, "Conditions do not hold yet")
+			}
+			if allPass {
+				testutils.Logf(t, "All conditions hold, returning")
+				return
+			}
+			if time.Now().After(deadline) {
+				testutils.Logf(t, "After deadline, exiting")
+				return
+			}
+		case <-ctx.Done():
+			testutils.Logf(t, "waitTillHolds: Context cancelled, cause: %v", context.Cause(ctx))
+			return
+		}
+	}
+}
+
+func logsContain(out string, expected []string) error {
+	for _, substring := range expected {
+		if !strings.Contains(out, substring) {
+			return fmt.Errorf("logs do not contain %q yet", substring)
+		}
+	}
+	return nil
+}
+
+func stateFile(env map[string]string, name string) string {
+	return filepath.Join(env["XDG_STATE_HOME"], "hyprwhenthen", name)
 }
 
 func compareWithFixture(t *testing.T, target, fixture string) {
@@ -0,0 +1,88 @@
+package test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fiffeek/hyprwhenthen/internal/config"
+	"github.com/fiffeek/hyprwhenthen/internal/utils"
+	"github.com/stretchr/testify/require"
+)
+
+var schemaFile = filepath.Join(basepath, "schema.json")
+
+func Test__Schema(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	cmd := prepBinaryRun(ctx, []string{"schema"}, []string{})
+	out, err := cmd.Output()
+	require.NoError(t, err, "binary failed %s", string(out))
+
+	target := filepath.Join(t.TempDir(), "schema.json")
+	require.NoError(t, os.WriteFile(target, out, 0o600))
+	compareWithFixture(t, target, schemaFile)
+}
+
+// Test__Schema_Covers_Configs makes sure that every key used in the test and
+// example configs is described by the schema.
+func Test__Schema_Covers_Configs(t *testing.T) {
+	schema, err := config.GenerateSchema()
+	require.NoError(t, err)
+
+	var files []string
+	for _, root := range []string{filepath.Join(basepath, "test", "testdata", "configs"), examples} {
+		for _, ext := range []string{".toml", ".yaml", ".json"} {
+			found, err := utils.Find(root, ext)
+			require.NoError(t, err)
+			files = append(files, found...)
+		}
+	}
+	require.NotEmpty(t, files)
+
+	for _, file := range files {
+		name := filepath.Base(file)
+		// These configs have unknown keys on purpose.
+		if name == "should_fail_multiple_errors.toml" || name == "should_lint_config.toml" {
+			continue
+		}
+		t.Run(name, func(t *testing.T) {
+			// nolint:gosec
+			contents, err := os.ReadFile(file)
+			require.NoError(t, err)
+			doc, err := config.DecodeDocument(contents, config.DetectFormat(file))
+			if err != nil {
+				t.Skipf("not a valid document: %v", err)
+			}
+			assertKeysInSchema(t, schema, schema, doc, "")
+		})
+	}
+}
+
+func assertKeysInSchema(t *testing.T, root, schema *config.Schema, value any, path string) {
+	if schema.Ref != "" {
+		schema = root.Defs[strings.TrimPrefix(schema.Ref, "#/$defs/")]
+		require.NotNil(t, schema, "unknown ref at %s", path)
+	}
+	switch v := value.(type) {
+	case map[string]any:
+		for key, item := range v {
+			property, ok := schema.Properties[key]
+			if !ok {
+				additional, ok := schema.AdditionalProperties.(*config.Schema)
+				require.True(t, ok, "key %s%s is not in the schema", path, key)
+				property = additional
+			}
+			assertKeysInSchema(t, root, property, item, path+key+".")
+		}
+	case []any:
+		require.NotNil(t, schema.Items, "%s is not an array in the schema", path)
+		for _, item := range v {
+			assertKeysInSchema(t, root, schema.Items, item, path)
+		}
+	}
+}
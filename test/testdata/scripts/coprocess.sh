@@ -0,0 +1,13 @@
+#!/usr/bin/env bash
+# Appends the context of every received event to $TMP_TST_FILE_0,
+# exits after the first event when $ONCE is set.
+echo "started" >>"$TMP_TST_FILE_0"
+while read -r line; do
+	if [[ $line =~ \"context\":\"([^\"]*)\" ]]; then
+		echo "${BASH_REMATCH[1]}" >>"$TMP_TST_FILE_0"
+	fi
+	echo "ack"
+	if [[ -n $ONCE ]]; then
+		exit 1
+	fi
+done
@@ -0,0 +1,11 @@
+#!/usr/bin/env bash
+# Prints a line longer than the pipe capacity for every received event and
+# appends the context to $TMP_TST_FILE_0 once the line was read.
+while read -r line; do
+	head -c 300000 /dev/zero | tr '\0' 'a'
+	echo
+	if [[ $line =~ \"context\":\"([^\"]*)\" ]]; then
+		echo "${BASH_REMATCH[1]}" >>"$TMP_TST_FILE_0"
+	fi
+	echo "ack"
+done
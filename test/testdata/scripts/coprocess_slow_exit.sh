@@ -0,0 +1,11 @@
+#!/usr/bin/env bash
+# Appends the context of the first event to $TMP_TST_FILE_0 and exits slowly,
+# so that the next event is written before the exit is noticed.
+echo "started" >>"$TMP_TST_FILE_0"
+read -r line
+if [[ $line =~ \"context\":\"([^\"]*)\" ]]; then
+	echo "${BASH_REMATCH[1]}" >>"$TMP_TST_FILE_0"
+fi
+echo "ack"
+sleep 0.1
+exit 1